@@ -0,0 +1,270 @@
+package cosmovisor
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// BackupFormat is how the data dir is backed up before an upgrade
+type BackupFormat string
+
+const (
+	// BackupCopy copies the data dir into backups/<plan>/data (the default)
+	BackupCopy BackupFormat = "copy"
+	// BackupTarZst streams the data dir into backups/<plan>/data.tar.zst
+	BackupTarZst BackupFormat = "tar.zst"
+	// BackupTarGz streams the data dir into backups/<plan>/data.tar.gz
+	BackupTarGz BackupFormat = "tar.gz"
+	// BackupHardlink recreates the data dir in backups/<plan>/data, hardlinking immutable files instead of copying them
+	BackupHardlink BackupFormat = "hardlink"
+)
+
+// archiveFormats are the formats that write an archive, in the order a restore looks for them
+var archiveFormats = []BackupFormat{BackupTarZst, BackupTarGz}
+
+// backupArchive is the archive file of a backup dir in the given format
+func backupArchive(backupDir string, format BackupFormat) string {
+	return filepath.Join(backupDir, "data."+string(format))
+}
+
+// writeArchive streams the tree at src, as far as filter takes it, into a compressed tar at dst,
+// and syncs it to disk before returning. A partially written dst is removed on failure.
+// With record set, the files are recorded with the checksum of what went into the archive.
+func writeArchive(src, dst string, format BackupFormat, filter *dataFilter, record *manifestRecorder) (err error) {
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(dst)
+		}
+	}()
+
+	var zw io.WriteCloser
+	switch format {
+	case BackupTarZst:
+		if zw, err = zstd.NewWriter(f); err != nil {
+			return err
+		}
+	case BackupTarGz:
+		zw = gzip.NewWriter(f)
+	default:
+		return fmt.Errorf("unknown archive format %q", format)
+	}
+
+	tw := tar.NewWriter(zw)
+	if err := walkData(src, filter, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return addToArchive(tw, src, path, info, record)
+	}); err != nil {
+		zw.Close()
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		zw.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// addToArchive writes a single file, dir or symlink of the tree at root to tw, recording the files in record
+func addToArchive(tw *tar.Writer, root, path string, info os.FileInfo, record *manifestRecorder) error {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return err
+	}
+
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		if link, err = os.Readlink(path); err != nil {
+			return err
+		}
+	} else if !info.Mode().IsRegular() && !info.IsDir() {
+		// sockets, pipes and devices don't belong in a data dir
+		return nil
+	}
+
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = filepath.ToSlash(rel)
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if record == nil {
+		_, err = io.Copy(tw, in)
+		return err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tw, h), in)
+	if err != nil {
+		return err
+	}
+	record.record(rel, info, n, h.Sum(nil))
+	return nil
+}
+
+// openArchive opens an archive written by writeArchive for reading, close releases it
+func openArchive(src string, format BackupFormat) (tr *tar.Reader, close func(), err error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var r io.Reader
+	closeZ := func() {}
+	switch format {
+	case BackupTarZst:
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		r, closeZ = zr, zr.Close
+	case BackupTarGz:
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		r, closeZ = zr, func() { zr.Close() }
+	default:
+		f.Close()
+		return nil, nil, fmt.Errorf("unknown archive format %q", format)
+	}
+	return tar.NewReader(r), func() { closeZ(); f.Close() }, nil
+}
+
+// extractArchive unpacks an archive written by writeArchive into dst.
+// The dirs get their modes and modification times once everything is extracted,
+// so a read-only dir can still be filled and its mtime isn't changed by what is written into it.
+func extractArchive(src, dst string, format BackupFormat) error {
+	tr, close, err := openArchive(src, format)
+	if err != nil {
+		return err
+	}
+	defer close()
+
+	if err := os.MkdirAll(dst, 0700); err != nil {
+		return err
+	}
+	root, err := filepath.EvalSymlinks(dst)
+	if err != nil {
+		return err
+	}
+	var dirs []copyFileJob
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return setDirModes(dirs)
+		}
+		if err != nil {
+			return err
+		}
+		if err := extractEntry(tr, hdr, dst, root); err != nil {
+			return fmt.Errorf("extracting %s: %w", hdr.Name, err)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			dirs = append(dirs, copyFileJob{dst: filepath.Join(dst, filepath.Clean(filepath.FromSlash(hdr.Name))), info: hdr.FileInfo()})
+		}
+	}
+}
+
+// extractEntry writes a single tar entry below dst, whose symlinks resolve to root,
+// refusing entries that point outside of it, by name or through a symlink extracted before
+func extractEntry(tr *tar.Reader, hdr *tar.Header, dst, root string) error {
+	name := filepath.Clean(filepath.FromSlash(hdr.Name))
+	if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("entry is outside of the archive root")
+	}
+	target := filepath.Join(dst, name)
+	if err := checkInside(root, target); err != nil {
+		return err
+	}
+	mode := os.FileMode(hdr.Mode).Perm()
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		return os.MkdirAll(target, 0700)
+	case tar.TypeSymlink:
+		if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+			return err
+		}
+		return os.Symlink(hdr.Linkname, target)
+	case tar.TypeReg:
+		if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+			return err
+		}
+		// never write through whatever is there already, it may be a symlink
+		if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, tr); err != nil {
+			out.Close()
+			return err
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+		// the umask may have narrowed the mode, keep it as archived
+		if err := os.Chmod(target, mode); err != nil {
+			return err
+		}
+		return os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+	default:
+		return nil
+	}
+}
+
+// checkInside makes sure path, or the closest of its parents that exists, resolves to root or below it
+func checkInside(root, path string) error {
+	for dir := path; ; dir = filepath.Dir(dir) {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if os.IsNotExist(err) && dir != filepath.Dir(dir) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, resolved)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("%s resolves outside of the archive root", dir)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,55 @@
+package cosmovisor
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractArchiveDirModes(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "data")
+	writeDataDir(t, src, 4, 10)
+	mtime := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, os.Chtimes(filepath.Join(src, "db1.db"), mtime, mtime))
+	require.NoError(t, os.Chmod(filepath.Join(src, "db1.db"), 0555))
+	t.Cleanup(func() { os.Chmod(filepath.Join(src, "db1.db"), 0755) })
+
+	archive := filepath.Join(t.TempDir(), "data.tar.gz")
+	require.NoError(t, writeArchive(src, archive, BackupTarGz, nil, nil))
+	dst := filepath.Join(t.TempDir(), "data")
+	require.NoError(t, extractArchive(archive, dst, BackupTarGz))
+	t.Cleanup(func() { os.Chmod(filepath.Join(dst, "db1.db"), 0755) })
+
+	// the read-only dir was still filled, and keeps its mtime
+	require.FileExists(t, filepath.Join(dst, "db1.db", "000001.sst"))
+	info, err := os.Stat(filepath.Join(dst, "db1.db"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0555), info.Mode().Perm())
+	require.True(t, mtime.Equal(info.ModTime()), info.ModTime())
+}
+
+func TestExtractArchiveRefusesSymlinkParents(t *testing.T) {
+	outside := t.TempDir()
+	archive := filepath.Join(t.TempDir(), "data.tar.gz")
+	f, err := os.Create(archive)
+	require.NoError(t, err)
+	zw := gzip.NewWriter(f)
+	tw := tar.NewWriter(zw)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "escape", Linkname: outside}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "escape/file", Mode: 0644, Size: 4}))
+	_, err = tw.Write([]byte("pwn\n"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, zw.Close())
+	require.NoError(t, f.Close())
+
+	err = extractArchive(archive, filepath.Join(t.TempDir(), "data"), BackupTarGz)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "outside of the archive root")
+	require.NoFileExists(t, filepath.Join(outside, "file"))
+}
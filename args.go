@@ -1,11 +1,15 @@
 package cosmovisor
 
 import (
-	"errors"
 	"fmt"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
 )
 
 const (
@@ -18,11 +22,42 @@ const (
 
 // Config is the information passed in to control the daemon
 type Config struct {
+	// sources records where each value was set, keyed by config file key
+	sources               map[string]string
+	configFile            string
 	Home                  string
 	Name                  string
+	DataDir               string
+	BackupFormat          BackupFormat
+	ImmutablePatterns     []string
+	BackupExtraPaths      []string
+	BackupExclude         []string
+	BackupInclude         []string
+	BackupWorkers         int
+	BackupRateLimit       int64
+	BackupKeep            int
+	BackupMaxSize         int64
+	BackupMaxAge          time.Duration
+	DiskSpacePolicy       DiskSpacePolicy
+	RestartPolicy         RestartPolicy
+	RestartDelay          time.Duration
+	RestartBackoff        float64
+	RestartMaxDelay       time.Duration
+	RestartMaxRestarts    int
+	RestartWindow         time.Duration
+	ShutdownSignal        string
+	ShutdownGrace         time.Duration
+	ForwardSignals        []string
+	UpgradeDetectors      []string
+	UpgradeRegex          string
+	TrustedKeys           string
+	PlanEndpoint          string
+	PlanPollInterval      time.Duration
+	PollInterval          time.Duration
 	AllowDownloadBinaries bool
 	RestartAfterUpgrade   bool
-	DataDir               string
+	WatchUpgradeInfo      bool
+	RequireChecksum       bool
 }
 
 // Root returns the root directory where all info lives
@@ -91,64 +126,176 @@ func (cfg *Config) CurrentBin() (string, error) {
 	return filepath.Join(dest, "bin", cfg.Name), nil
 }
 
-// GetConfigFromEnv will read the environmental variables into a config
+// GetConfigFromEnv will read the config file and environmental variables into a config
 // and then validate it is reasonable
 func GetConfigFromEnv() (*Config, error) {
-	cfg := &Config{
-		Home:    os.Getenv("DAEMON_HOME"),
-		Name:    os.Getenv("DAEMON_NAME"),
-		DataDir: os.Getenv("DAEMON_BACKUP_DATA_DIR"),
-	}
-
-	if os.Getenv("DAEMON_ALLOW_DOWNLOAD_BINARIES") == "true" {
-		cfg.AllowDownloadBinaries = true
-	}
-
-	if os.Getenv("DAEMON_RESTART_AFTER_UPGRADE") == "true" {
-		cfg.RestartAfterUpgrade = true
-	}
-
-	if err := cfg.validate(); err != nil {
-		return nil, err
-	}
-
-	return cfg, nil
+	return LoadConfig(nil)
 }
 
 // validate returns an error if this config is invalid.
 // it enforces Home/cosmovisor is a valid directory and exists,
-// and that Name is set
+// and that Name is set. Errors name the source of the bad value.
 func (cfg *Config) validate() error {
 	if cfg.Name == "" {
-		return errors.New("DAEMON_NAME is not set")
+		return fmt.Errorf("%s is not set", cfg.describe("name"))
 	}
 
 	if cfg.Home == "" {
-		return errors.New("DAEMON_HOME is not set")
+		return fmt.Errorf("%s is not set", cfg.describe("home"))
 	}
 
 	if !filepath.IsAbs(cfg.Home) {
-		return errors.New("DAEMON_HOME must be an absolute path")
+		return fmt.Errorf("%s must be an absolute path", cfg.describe("home"))
 	}
 
 	if cfg.DataDir != "" {
 		if !filepath.IsAbs(cfg.DataDir) {
-			return errors.New("DAEMON_BACKUP_DATA_DIR must be an absolute path")
+			return fmt.Errorf("%s must be an absolute path", cfg.describe("backup_data_dir"))
 		}
 
 		info, err := os.Stat(cfg.DataDir)
 		if err != nil {
-			return fmt.Errorf("cannot stat data dir: %w", err)
+			return fmt.Errorf("cannot stat data dir set by %s: %w", cfg.describe("backup_data_dir"), err)
 		}
 		if !info.IsDir() {
 			return fmt.Errorf("%s is not a directory", info.Name())
 		}
 	}
 
+	switch cfg.BackupFormat {
+	case "", BackupCopy, BackupTarZst, BackupTarGz, BackupHardlink:
+	default:
+		return fmt.Errorf("%s must be one of %s, %s, %s or %s, got %q",
+			cfg.describe("backup_format"), BackupCopy, BackupTarZst, BackupTarGz, BackupHardlink, cfg.BackupFormat)
+	}
+
+	for _, pattern := range cfg.ImmutablePatterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q in %s: %w", pattern, cfg.describe("backup_immutable_patterns"), err)
+		}
+	}
+
+	for _, extra := range cfg.BackupExtraPaths {
+		rel := filepath.Clean(extra)
+		if filepath.IsAbs(rel) || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("%s must list paths inside the node home, relative to it, got %q", cfg.describe("backup_extra_paths"), extra)
+		}
+		if cfg.DataDir != "" && overlaps(filepath.Join(cfg.Home, rel), cfg.DataDir) {
+			return fmt.Errorf("%s overlaps the data dir, got %q", cfg.describe("backup_extra_paths"), extra)
+		}
+	}
+
+	for key, patterns := range map[string][]string{"backup_exclude": cfg.BackupExclude, "backup_include": cfg.BackupInclude} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(strings.Trim(pattern, "/"), ""); err != nil {
+				return fmt.Errorf("invalid pattern %q in %s: %w", pattern, cfg.describe(key), err)
+			}
+		}
+	}
+
+	switch cfg.DiskSpacePolicy {
+	case "", DiskSpaceFail, DiskSpaceSkipBackup:
+	default:
+		return fmt.Errorf("%s must be %s or %s, got %q",
+			cfg.describe("disk_space_policy"), DiskSpaceFail, DiskSpaceSkipBackup, cfg.DiskSpacePolicy)
+	}
+
+	if cfg.BackupKeep < 0 {
+		return fmt.Errorf("%s cannot be negative", cfg.describe("backup_keep"))
+	}
+	if cfg.BackupMaxAge < 0 {
+		return fmt.Errorf("%s cannot be negative", cfg.describe("backup_max_age"))
+	}
+	if cfg.BackupWorkers < 0 {
+		return fmt.Errorf("%s cannot be negative", cfg.describe("backup_workers"))
+	}
+
+	switch cfg.RestartPolicy {
+	case "", RestartNever, RestartOnFailure, RestartAlways:
+	default:
+		return fmt.Errorf("%s must be one of %s, %s or %s, got %q",
+			cfg.describe("restart_policy"), RestartNever, RestartOnFailure, RestartAlways, cfg.RestartPolicy)
+	}
+
+	if cfg.RestartDelay < 0 {
+		return fmt.Errorf("%s cannot be negative", cfg.describe("restart_delay"))
+	}
+	if cfg.RestartMaxDelay < 0 {
+		return fmt.Errorf("%s cannot be negative", cfg.describe("restart_max_delay"))
+	}
+	if cfg.RestartWindow < 0 {
+		return fmt.Errorf("%s cannot be negative", cfg.describe("restart_window"))
+	}
+
+	if cfg.RestartBackoff != 0 && cfg.RestartBackoff < 1 {
+		return fmt.Errorf("%s must be at least 1", cfg.describe("restart_backoff"))
+	}
+
+	if cfg.RestartMaxRestarts < 0 {
+		return fmt.Errorf("%s cannot be negative", cfg.describe("restart_max_restarts"))
+	}
+
+	if cfg.ShutdownSignal != "" {
+		sig, err := parseSignal(cfg.ShutdownSignal)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", cfg.describe("shutdown_signal"), err)
+		}
+		// SIGKILL is the old immediate kill, any other signal may not stop the node at all
+		if sig != syscall.SIGTERM && sig != syscall.SIGINT && sig != syscall.SIGKILL {
+			return fmt.Errorf("invalid %s: %s does not stop the daemon, expected SIGTERM, SIGINT or SIGKILL", cfg.describe("shutdown_signal"), cfg.ShutdownSignal)
+		}
+	}
+
+	if cfg.ShutdownGrace < 0 {
+		return fmt.Errorf("%s cannot be negative", cfg.describe("shutdown_grace"))
+	}
+
+	for _, name := range cfg.ForwardSignals {
+		if _, err := parseSignal(name); err != nil {
+			return fmt.Errorf("invalid %s: %w", cfg.describe("forward_signals"), err)
+		}
+	}
+
+	if cfg.UpgradeRegex != "" {
+		re, err := regexp.Compile(cfg.UpgradeRegex)
+		if err == nil {
+			_, err = NewRegexDetector(re)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", cfg.describe("upgrade_regex"), err)
+		}
+	}
+
+	if _, err := NewDetector(cfg); err != nil {
+		return fmt.Errorf("invalid %s: %w", cfg.describe("upgrade_detectors"), err)
+	}
+
+	if cfg.TrustedKeys != "" {
+		if !filepath.IsAbs(cfg.TrustedKeys) {
+			return fmt.Errorf("%s must be an absolute path", cfg.describe("trusted_keys"))
+		}
+		if _, err := LoadKeyring(cfg.TrustedKeys); err != nil {
+			return fmt.Errorf("invalid %s: %w", cfg.describe("trusted_keys"), err)
+		}
+	}
+
+	if cfg.PlanEndpoint != "" {
+		if u, err := url.Parse(cfg.PlanEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("%s must be an http or https url", cfg.describe("plan_endpoint"))
+		}
+		if cfg.PlanPollInterval <= 0 {
+			return fmt.Errorf("%s must be positive", cfg.describe("plan_poll_interval"))
+		}
+	}
+
+	if cfg.WatchUpgradeInfo && cfg.PollInterval <= 0 {
+		return fmt.Errorf("%s must be positive to watch %s", cfg.describe("poll_interval"), upgradeInfoFileName)
+	}
+
 	// ensure the root directory exists
 	info, err := os.Stat(cfg.Root())
 	if err != nil {
-		return fmt.Errorf("cannot stat home dir: %w", err)
+		return fmt.Errorf("cannot stat home dir set by %s: %w", cfg.describe("home"), err)
 	}
 
 	if !info.IsDir() {
@@ -157,3 +304,12 @@ func (cfg *Config) validate() error {
 
 	return nil
 }
+
+// overlaps reports whether one of a and b is, or is inside, the other
+func overlaps(a, b string) bool {
+	a, b = filepath.Clean(a), filepath.Clean(b)
+	inside := func(p, dir string) bool {
+		return p == dir || strings.HasPrefix(p, dir+string(filepath.Separator))
+	}
+	return inside(a, b) || inside(b, a)
+}
@@ -1,16 +1,30 @@
 package cosmovisor
 
 import (
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"hash"
+	"io"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/otiai10/copy"
 )
 
+// backupHomeDir is the dir of a backup that holds the extra paths, laid out as in the node home
+const backupHomeDir = "home"
+
 // BackupData backs up the data directory located at $DAEMON_BACKUP_DATA_DIR to
 // $DAEMON_HOME/backups/$plan/data and create keep at $DAEMON_HOME/backups/$plan/.keep
+// With an archive backup format, the data goes into $DAEMON_HOME/backups/$plan/data.<format> instead.
+// The extra paths are copied into $DAEMON_HOME/backups/$plan/home, whatever the format.
 func BackupData(cfg *Config, upgradeInfo *UpgradeInfo) error {
 	backupDir := cfg.BackupDir(upgradeInfo.Name)
 	// Stamp file for completion tracking.
@@ -19,24 +33,582 @@ func BackupData(cfg *Config, upgradeInfo *UpgradeInfo) error {
 	if _, err := os.Stat(backupStamp); err == nil {
 		return nil
 	}
+	// Clear what an interrupted backup left, its files may be hardlinks of the live data and must not be written to.
+	if err := os.RemoveAll(backupDir); err != nil {
+		return fmt.Errorf("removing incomplete backup: %w", err)
+	}
+	// Make sure the backup fits, rather than filling the disk halfway.
+	need, err := backupSpace(cfg, backupDir)
+	if err != nil {
+		return fmt.Errorf("sizing data dir: %w", err)
+	}
+	if err := checkDiskSpace(backupDir, need, "the data backup"); err != nil {
+		if cfg.DiskSpacePolicy != DiskSpaceSkipBackup {
+			return err
+		}
+		Logger.Printf("skipping the data backup for %s: %v", upgradeInfo.Name, err)
+		return nil
+	}
+	// The files are listed and hashed as they are backed up, to verify restores and the backup itself against.
+	manifest := newBackupManifest(cfg, upgradeInfo)
+	record := &manifestRecorder{}
 	// Make backup dir if it doesn't exist.
 	if _, err := os.Stat(backupDir); os.IsNotExist(err) {
 		if err := os.MkdirAll(backupDir, 0700); err != nil {
 			return err
 		}
 	}
-	// Perform the copy from data src -> backup dst.
-	if err := copy.Copy(cfg.DataDir, filepath.Join(backupDir, "data")); err != nil {
+	switch cfg.BackupFormat {
+	case BackupTarZst, BackupTarGz:
+		// Stream the data into an archive, synced before the stamp is written.
+		if err := writeArchive(cfg.DataDir, backupArchive(backupDir, cfg.BackupFormat), cfg.BackupFormat, cfg.backupFilter(), record); err != nil {
+			return err
+		}
+		if err := syncDir(backupDir); err != nil {
+			return err
+		}
+	case BackupHardlink:
+		// The node is stopped, so the immutable files can be shared with the data dir.
+		patterns := cfg.ImmutablePatterns
+		if len(patterns) == 0 {
+			patterns = defaultImmutablePatterns
+		}
+		linked, copied, err := hardlinkTree(cfg.DataDir, filepath.Join(backupDir, "data"), patterns, cfg.backupFilter(), record)
+		if err != nil {
+			return err
+		}
+		Logger.Printf("backed up %s: linked %d immutable files, copied %d", cfg.DataDir, linked, copied)
+	default:
+		// Perform the copy from data src -> backup dst.
+		opts := cfg.copyOptions()
+		opts.filter, opts.record = cfg.backupFilter(), record
+		if err := copyTree(cfg.DataDir, filepath.Join(backupDir, "data"), opts); err != nil {
+			return err
+		}
+	}
+	for _, e := range record.sorted() {
+		manifest.add(e)
+	}
+	// Copy the extra paths, like the node config, into home/ next to the data.
+	for _, rel := range manifest.ExtraPaths {
+		dst := filepath.Join(backupDir, backupHomeDir, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+			return err
+		}
+		opts := cfg.copyOptions()
+		opts.record = &manifestRecorder{}
+		if err := copyTree(filepath.Join(cfg.Home, rel), dst, opts); err != nil {
+			return err
+		}
+		for _, e := range opts.record.sorted() {
+			e.Path = path.Join(filepath.ToSlash(rel), e.Path)
+			manifest.Extra = append(manifest.Extra, e)
+		}
+	}
+	if err := writeManifest(backupDir, manifest); err != nil {
 		return err
 	}
 	// Touch the stamp file if everything completed.
 	if _, err := TouchFile(backupStamp); err != nil {
 		return err
 	}
+	// Make room by removing old backups, a failure here doesn't fail the upgrade.
+	pruned, err := PruneBackups(cfg, false)
+	for _, item := range pruned {
+		Logger.Printf("removed backup %s (%s): %s", item.Name, HumanSize(item.Size), item.Reason)
+	}
+	if err != nil {
+		Logger.Printf("removing old backups: %v", err)
+	}
 	// Success!
 	return nil
 }
 
+// RestoreData restores the backup taken before the named upgrade into dest, which must not exist yet.
+// Archived backups are extracted, copied backups are copied.
+func RestoreData(cfg *Config, upgradeName, dest string) error {
+	backupDir := cfg.BackupDir(upgradeName)
+	if _, err := os.Stat(filepath.Join(backupDir, ".keep")); err != nil {
+		return fmt.Errorf("no complete backup for upgrade %s in %s", upgradeName, backupDir)
+	}
+	if _, err := os.Lstat(dest); err == nil {
+		return fmt.Errorf("%s already exists", dest)
+	}
+
+	manifest, err := ReadManifest(backupDir)
+	if err != nil {
+		return err
+	}
+
+	if err := copyBackup(cfg, backupDir, dest); err != nil {
+		return err
+	}
+	if manifest == nil {
+		Logger.Printf("backup of %s has no manifest, the restored data is not verified", upgradeName)
+		return nil
+	}
+	if manifest.Partial {
+		Logger.Printf("backup of %s is partial, the restored data lacks what matches %s (except %s)",
+			upgradeName, strings.Join(manifest.Excluded, ","), strings.Join(manifest.Included, ","))
+	}
+	if err := manifest.Verify(dest); err != nil {
+		return fmt.Errorf("restored data doesn't match the manifest: %w", err)
+	}
+	return nil
+}
+
+// copyBackup extracts or copies the data backup in backupDir to dest
+func copyBackup(cfg *Config, backupDir, dest string) error {
+	for _, format := range archiveFormats {
+		archive := backupArchive(backupDir, format)
+		if _, err := os.Stat(archive); err == nil {
+			return extractArchive(archive, dest, format)
+		}
+	}
+	return copyTree(filepath.Join(backupDir, "data"), dest, cfg.copyOptions())
+}
+
+// copyProgressInterval is how often a running copy logs its progress
+var copyProgressInterval = 10 * time.Second
+
+// copyChunk is how much of a file is read and written at once, and the unit the rate limit waits for
+const copyChunk = 256 * 1024
+
+// copyOptions tunes copyTree
+type copyOptions struct {
+	// workers is how many files are copied at once
+	workers int
+	// rate is the most bytes copied per second, 0 for no limit
+	rate int64
+	// filter leaves parts of src out, nil to copy everything
+	filter *dataFilter
+	// record gets every file copied, with the checksum of what was written, nil to skip hashing
+	record *manifestRecorder
+}
+
+// copyOptions is how cfg copies data dirs, one worker per CPU unless set
+func (cfg *Config) copyOptions() copyOptions {
+	opts := copyOptions{workers: cfg.BackupWorkers, rate: cfg.BackupRateLimit}
+	if opts.workers <= 0 {
+		opts.workers = runtime.NumCPU()
+	}
+	return opts
+}
+
+// copyFileJob is a regular file for the copy workers, rel is its path below the tree copied
+type copyFileJob struct {
+	src, dst, rel string
+	info          os.FileInfo
+}
+
+// copyTree copies the tree at src to dst, keeping modes and modification times, files already in dst are replaced.
+// Dirs and symlinks are made first, then the files are copied by opts.workers workers sharing the rate limit.
+// Progress is logged every copyProgressInterval, and the first failure stops the copy.
+func copyTree(src, dst string, opts copyOptions) error {
+	var files []copyFileJob
+	var dirs []copyFileJob
+	var total int64
+	err := walkData(src, opts.filter, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			// writable until the files are in, the mode is set at the end
+			dirs = append(dirs, copyFileJob{src: path, dst: target, info: info})
+			return os.MkdirAll(target, 0700)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			files = append(files, copyFileJob{src: path, dst: target, rel: rel, info: info})
+			total += info.Size()
+		}
+		// sockets, pipes and devices don't belong in a data dir
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	progress := &copyProgress{totalFiles: len(files), totalBytes: total, start: time.Now()}
+	throttle := newThrottle(opts.rate)
+	jobs := make(chan copyFileJob)
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	var firstErr error
+	fail := func(err error) {
+		stopOnce.Do(func() {
+			firstErr = err
+			close(stop)
+		})
+	}
+
+	var wg sync.WaitGroup
+	workers := opts.workers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, copyChunk)
+			for job := range jobs {
+				if err := copyFileThrottled(job, buf, throttle, progress, opts.record); err != nil {
+					fail(fmt.Errorf("copying %s: %w", job.src, err))
+				}
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(copyProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				Logger.Printf("copying %s: %s", src, progress)
+			case <-done:
+				return
+			}
+		}
+	}()
+	defer close(done)
+
+feed:
+	for _, job := range files {
+		select {
+		case jobs <- job:
+		case <-stop:
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+
+	if err := setDirModes(dirs); err != nil {
+		return err
+	}
+	if len(files) > 0 && time.Since(progress.start) >= copyProgressInterval {
+		Logger.Printf("copied %s: %s", src, progress)
+	}
+	return nil
+}
+
+// setDirModes gives the copied dirs the modes and modification times of their sources,
+// deepest dirs first, so setting a parent's mtime isn't undone by its children
+func setDirModes(dirs []copyFileJob) error {
+	for i := len(dirs) - 1; i >= 0; i-- {
+		dir := dirs[i]
+		if err := os.Chmod(dir.dst, dir.info.Mode().Perm()); err != nil {
+			return err
+		}
+		if err := os.Chtimes(dir.dst, dir.info.ModTime(), dir.info.ModTime()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFileThrottled copies a single file through buf, waiting on throttle before each chunk is written.
+// An existing dst is removed rather than truncated, as it may be a hardlink.
+// With record set, the file is recorded with the checksum of the chunks as they were written.
+func copyFileThrottled(job copyFileJob, buf []byte, throttle *throttle, progress *copyProgress, record *manifestRecorder) error {
+	in, err := os.Open(job.src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.Remove(job.dst); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	perm := job.info.Mode().Perm()
+	out, err := os.OpenFile(job.dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	var h hash.Hash
+	if record != nil {
+		h = sha256.New()
+	}
+	var written int64
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			throttle.wait(n)
+			if _, err := out.Write(buf[:n]); err != nil {
+				out.Close()
+				return err
+			}
+			if h != nil {
+				h.Write(buf[:n])
+			}
+			written += int64(n)
+			atomic.AddInt64(&progress.bytes, int64(n))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if h != nil {
+		record.record(job.rel, job.info, written, h.Sum(nil))
+	}
+	atomic.AddInt64(&progress.files, 1)
+	// the umask may have narrowed the mode, keep it as it was
+	if err := os.Chmod(job.dst, perm); err != nil {
+		return err
+	}
+	return os.Chtimes(job.dst, job.info.ModTime(), job.info.ModTime())
+}
+
+// copyProgress counts what a copy has done so far, files and bytes are updated atomically
+type copyProgress struct {
+	files, bytes int64
+	totalFiles   int
+	totalBytes   int64
+	start        time.Time
+}
+
+// String describes the progress, e.g. 120/400 files, 1.2GiB/4.0GiB, 25.0MiB/s, eta 2m10s
+func (p *copyProgress) String() string {
+	files, bytes := atomic.LoadInt64(&p.files), atomic.LoadInt64(&p.bytes)
+	elapsed := time.Since(p.start)
+	s := fmt.Sprintf("%d/%d files, %s/%s", files, p.totalFiles, HumanSize(bytes), HumanSize(p.totalBytes))
+	if bytes == 0 || elapsed <= 0 {
+		return s
+	}
+	rate := float64(bytes) / elapsed.Seconds()
+	eta := time.Duration(float64(p.totalBytes-bytes) / rate * float64(time.Second))
+	return fmt.Sprintf("%s, %s/s, eta %s", s, HumanSize(int64(rate)), eta.Round(time.Second))
+}
+
+// throttle limits how many bytes per second are passed on, shared by all the workers of a copy.
+// A nil throttle doesn't limit.
+type throttle struct {
+	mu   sync.Mutex
+	rate int64
+	// next is when the bytes reserved so far have been paid for
+	next time.Time
+}
+
+// newThrottle returns a throttle for rate bytes per second, or nil for no limit
+func newThrottle(rate int64) *throttle {
+	if rate <= 0 {
+		return nil
+	}
+	return &throttle{rate: rate}
+}
+
+// wait blocks until n more bytes fit in the rate, time not used earlier is not saved up for bursts
+func (t *throttle) wait(n int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	delay := t.next.Sub(now)
+	t.next = t.next.Add(time.Duration(float64(n) / float64(t.rate) * float64(time.Second)))
+	t.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// RestoreBackup replaces the data dir with the backup taken before the named upgrade, once it made sure the daemon isn't running.
+// The replaced data dir is moved aside next to itself rather than deleted, and moved back if the restore fails.
+// The extra paths in the backup are restored the same way, with the same suffix.
+// Unless force is set, a restore that would move a priv_validator_state.json backwards is undone and fails.
+// It returns where the replaced data was moved to.
+func RestoreBackup(cfg *Config, upgradeName string, force bool) (string, error) {
+	if cfg.DataDir == "" {
+		return "", errors.New("restoring data needs DAEMON_BACKUP_DATA_DIR to be set")
+	}
+	if err := checkNotRunning(cfg); err != nil {
+		return "", err
+	}
+	backupDir := cfg.BackupDir(upgradeName)
+	if _, err := os.Stat(filepath.Join(backupDir, ".keep")); err != nil {
+		return "", WithExitCode(ExitCodeBackup, fmt.Errorf("no complete backup for upgrade %s in %s", upgradeName, backupDir))
+	}
+	manifest, err := ReadManifest(backupDir)
+	if err != nil {
+		return "", WithExitCode(ExitCodeBackup, err)
+	}
+
+	swaps := []restoreSwap{{path: filepath.Clean(cfg.DataDir)}}
+	if manifest != nil {
+		for _, rel := range manifest.ExtraPaths {
+			swaps = append(swaps, restoreSwap{path: filepath.Join(cfg.Home, rel), backup: filepath.Join(backupDir, backupHomeDir, rel)})
+		}
+	}
+	// the same suffix for all, that none of them has been moved aside to already
+	stamp := ".replaced-" + time.Now().UTC().Format("20060102T150405Z")
+	suffix := stamp
+	for n := 2; ; n++ {
+		taken := false
+		for _, swap := range swaps {
+			if _, err := os.Lstat(swap.path + suffix); err == nil {
+				taken = true
+			}
+		}
+		if !taken {
+			break
+		}
+		suffix = fmt.Sprintf("%s-%d", stamp, n)
+	}
+	for i := range swaps {
+		swaps[i].moved = swaps[i].path + suffix
+	}
+
+	var done []restoreSwap
+	for i := range swaps {
+		swap := &swaps[i]
+		if _, err := os.Lstat(swap.path); os.IsNotExist(err) {
+			swap.moved = ""
+		} else if err := os.Rename(swap.path, swap.moved); err != nil {
+			return "", undoRestore(upgradeName, done, fmt.Errorf("moving %s aside: %w", swap.path, err))
+		}
+		done = append(done, *swap)
+
+		err := swap.restore(cfg, upgradeName)
+		if err == nil && !force && swap.moved != "" {
+			err = checkValidatorState(swap.path, swap.moved)
+		}
+		if err != nil {
+			return "", undoRestore(upgradeName, done, err)
+		}
+	}
+	return swaps[0].moved, nil
+}
+
+// restoreSwap is a path replaced by a restore
+type restoreSwap struct {
+	path string
+	// moved is where what was at path went, empty if there was nothing
+	moved string
+	// backup is the copy restored to path, empty for the data dir
+	backup string
+}
+
+// restore puts the backup in place of swap.path, once what was there has been moved aside
+func (swap *restoreSwap) restore(cfg *Config, upgradeName string) error {
+	if swap.backup == "" {
+		return RestoreData(cfg, upgradeName, swap.path)
+	}
+	if err := os.MkdirAll(filepath.Dir(swap.path), 0755); err != nil {
+		return err
+	}
+	return copyTree(swap.backup, swap.path, cfg.copyOptions())
+}
+
+// undoRestore removes what was restored and moves the replaced paths back, last first.
+// It returns the error that made the restore fail, along with any failure to undo it.
+func undoRestore(upgradeName string, done []restoreSwap, cause error) error {
+	for i := len(done) - 1; i >= 0; i-- {
+		swap := done[i]
+		err := os.RemoveAll(swap.path)
+		if err == nil && swap.moved != "" {
+			err = os.Rename(swap.moved, swap.path)
+		}
+		if err != nil {
+			return WithExitCode(ExitCodeBackup, fmt.Errorf("%v, and moving %s back from %s failed: %w", cause, swap.path, swap.moved, err))
+		}
+	}
+	return WithExitCode(ExitCodeBackup, fmt.Errorf("restoring backup of %s: %w", upgradeName, cause))
+}
+
+// BackupStatus describes a single backup in the backups dir
+type BackupStatus struct {
+	Name string
+	Dir  string
+	// Format is how the backup was taken
+	Format BackupFormat
+	// Taken is when the backup completed, or when it was last written to if it isn't complete
+	Taken time.Time
+	Size  int64
+	// Complete is true if the backup has its .keep stamp, an incomplete one is redone by the next upgrade to the same plan
+	Complete bool
+	// Partial is true if the backup left out parts of the data dir, see Manifest.Partial
+	Partial bool
+}
+
+// ListBackups returns the backups in the backups dir, newest first
+func ListBackups(cfg *Config) ([]BackupStatus, error) {
+	entries, err := os.ReadDir(filepath.Join(cfg.Root(), backupsDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading backups dir: %w", err)
+	}
+
+	var backups []BackupStatus
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		name, err := url.PathUnescape(entry.Name())
+		if err != nil {
+			continue
+		}
+		backup := BackupStatus{Name: name, Dir: cfg.BackupDir(name), Format: BackupCopy}
+		for _, format := range archiveFormats {
+			if _, err := os.Stat(backupArchive(backup.Dir, format)); err == nil {
+				backup.Format = format
+			}
+		}
+		if manifest, err := ReadManifest(backup.Dir); err == nil && manifest != nil {
+			backup.Format, backup.Partial = manifest.Format, manifest.Partial
+		}
+		if stamp, err := os.Stat(filepath.Join(backup.Dir, ".keep")); err == nil {
+			backup.Complete, backup.Taken = true, stamp.ModTime()
+		} else if info, err := entry.Info(); err == nil {
+			backup.Taken = info.ModTime()
+		}
+		if backup.Size, err = dirSize(backup.Dir); err != nil {
+			return nil, err
+		}
+		backups = append(backups, backup)
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Taken.After(backups[j].Taken) })
+	return backups, nil
+}
+
+// syncDir flushes the entries of a dir to disk, so files created in it survive a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
 // TouchFile creates a file at the location similar to the POSIX `touch` command.
 func TouchFile(file string) (time.Time, error) {
 	if _, err := os.Stat(file); os.IsNotExist(err) {
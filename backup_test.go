@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/provenance-io/cosmovisor"
@@ -87,6 +89,211 @@ func (s *upgradeTestSuite) TestNoBackupDataDir() {
 	s.Require().NoFileExists(keep)
 }
 
+func (s *upgradeTestSuite) TestBackupDataArchive() {
+	for _, format := range []cosmovisor.BackupFormat{cosmovisor.BackupTarZst, cosmovisor.BackupTarGz} {
+		home := copyTestData(s.T(), "validate")
+		data := filepath.Join(home, "data")
+		cfg := &cosmovisor.Config{Home: home, Name: "dummyd", DataDir: data, BackupFormat: format}
+		info := &cosmovisor.UpgradeInfo{Name: "chain2"}
+		s.Require().NoError(os.Symlink("application.db", filepath.Join(data, "latest.db")))
+
+		s.Require().NoError(cosmovisor.DoUpgrade(cfg, info))
+		// Only the archive and the keep file are written.
+		backupDir := cfg.BackupDir(info.Name)
+		s.Require().FileExists(filepath.Join(backupDir, "data."+string(format)))
+		s.Require().FileExists(filepath.Join(backupDir, ".keep"))
+		s.Require().NoDirExists(filepath.Join(backupDir, "data"))
+
+		// Restoring extracts the archive.
+		restored := filepath.Join(s.T().TempDir(), "data")
+		s.Require().NoError(cosmovisor.RestoreData(cfg, info.Name, restored))
+		for _, file := range []string{"application.db", "modulesDir/state.db"} {
+			bz, err := ioutil.ReadFile(filepath.Join(restored, file))
+			s.Require().NoError(err)
+			s.Require().Equal("test\n", string(bz))
+		}
+		link, err := os.Readlink(filepath.Join(restored, "latest.db"))
+		s.Require().NoError(err)
+		s.Require().Equal("application.db", link)
+		// Restoring won't overwrite.
+		s.Require().Error(cosmovisor.RestoreData(cfg, info.Name, restored))
+
+		// And a rollback restores it too.
+		s.Require().NoError(ioutil.WriteFile(filepath.Join(data, "application.db"), []byte("broken\n"), 0644))
+		_, err = cosmovisor.Rollback(cfg, "", true, false)
+		s.Require().NoError(err)
+		bz, err := ioutil.ReadFile(filepath.Join(data, "application.db"))
+		s.Require().NoError(err)
+		s.Require().Equal("test\n", string(bz))
+	}
+}
+
+func (s *upgradeTestSuite) TestBackupDataHardlink() {
+	home := copyTestData(s.T(), "validate")
+	data := filepath.Join(home, "data")
+	cfg := &cosmovisor.Config{Home: home, Name: "dummyd", DataDir: data, BackupFormat: cosmovisor.BackupHardlink}
+	info := &cosmovisor.UpgradeInfo{Name: "chain2"}
+	files := map[string]string{
+		"blockstore.db/000004.ldb":      "table 4",
+		"blockstore.db/000005.ldb":      "table 5",
+		"blockstore.db/MANIFEST-000006": "manifest",
+		"blockstore.db/CURRENT":         "MANIFEST-000006\n",
+		"blockstore.db/LOG":             "log",
+		"state.db/000007.sst":           "table 7",
+	}
+	for file, content := range files {
+		s.Require().NoError(os.MkdirAll(filepath.Dir(filepath.Join(data, file)), 0755))
+		s.Require().NoError(ioutil.WriteFile(filepath.Join(data, file), []byte(content), 0644))
+	}
+
+	s.Require().NoError(cosmovisor.DoUpgrade(cfg, info))
+	backup := filepath.Join(cfg.BackupDir(info.Name), "data")
+	s.Require().FileExists(filepath.Join(cfg.BackupDir(info.Name), ".keep"))
+	// Immutable files are shared with the data dir, the rest is copied.
+	for _, file := range []string{"blockstore.db/000004.ldb", "blockstore.db/000005.ldb", "state.db/000007.sst"} {
+		s.Require().True(s.sameFile(filepath.Join(data, file), filepath.Join(backup, file)), file)
+	}
+	for _, file := range []string{"blockstore.db/MANIFEST-000006", "blockstore.db/CURRENT", "blockstore.db/LOG", "application.db"} {
+		s.Require().False(s.sameFile(filepath.Join(data, file), filepath.Join(backup, file)), file)
+	}
+
+	// The node appends to the mutable files after the upgrade.
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(data, "blockstore.db/LOG"), []byte("log, and more"), 0644))
+
+	// The restored data is byte identical to the data at the time of the backup.
+	files["application.db"] = "test\n"
+	files["modulesDir/state.db"] = "test\n"
+	restored := filepath.Join(s.T().TempDir(), "data")
+	s.Require().NoError(cosmovisor.RestoreData(cfg, info.Name, restored))
+	found := 0
+	s.Require().NoError(filepath.Walk(restored, func(path string, fi os.FileInfo, err error) error {
+		s.Require().NoError(err)
+		if fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(restored, path)
+		s.Require().NoError(err)
+		bz, err := ioutil.ReadFile(path)
+		s.Require().NoError(err)
+		s.Require().Equal(files[filepath.ToSlash(rel)], string(bz), rel)
+		s.Require().False(s.sameFile(filepath.Join(backup, rel), path), rel)
+		found++
+		return nil
+	}))
+	s.Require().Equal(len(files), found)
+}
+
+func (s *upgradeTestSuite) TestRestoreBackup() {
+	home := copyTestData(s.T(), "validate")
+	data := filepath.Join(home, "data")
+	cfg := &cosmovisor.Config{Home: home, Name: "dummyd", DataDir: data}
+	s.Require().NoError(cosmovisor.DoUpgrade(cfg, &cosmovisor.UpgradeInfo{Name: "chain2"}))
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(data, "application.db"), []byte("broken\n"), 0644))
+
+	// not while the daemon runs
+	s.Require().NoError(ioutil.WriteFile(cfg.PIDFile(), []byte(fmt.Sprintln(os.Getpid())), 0644))
+	_, err := cosmovisor.RestoreBackup(cfg, "chain2", false)
+	s.Require().ErrorIs(err, cosmovisor.ErrDaemonRunning)
+	// a pid file of a process that is gone doesn't count
+	s.Require().NoError(ioutil.WriteFile(cfg.PIDFile(), []byte("2147483647\n"), 0644))
+
+	// the restored data has to match the manifest, or the data dir is put back
+	stateDb := filepath.Join(cfg.BackupDir("chain2"), "data", "modulesDir", "state.db")
+	s.Require().NoError(ioutil.WriteFile(stateDb, []byte("tampered\n"), 0644))
+	_, err = cosmovisor.RestoreBackup(cfg, "chain2", false)
+	s.Require().Error(err)
+	s.Require().Contains(err.Error(), "modulesDir/state.db is 9 bytes, expected 5")
+	bz, err := ioutil.ReadFile(filepath.Join(data, "application.db"))
+	s.Require().NoError(err)
+	s.Require().Equal("broken\n", string(bz))
+
+	s.Require().NoError(ioutil.WriteFile(stateDb, []byte("test\n"), 0644))
+	moved, err := cosmovisor.RestoreBackup(cfg, "chain2", false)
+	s.Require().NoError(err)
+	bz, err = ioutil.ReadFile(filepath.Join(data, "application.db"))
+	s.Require().NoError(err)
+	s.Require().Equal("test\n", string(bz))
+	bz, err = ioutil.ReadFile(filepath.Join(moved, "application.db"))
+	s.Require().NoError(err)
+	s.Require().Equal("broken\n", string(bz))
+
+	_, err = cosmovisor.RestoreBackup(cfg, "chain3", false)
+	s.Require().Error(err)
+}
+
+func (s *upgradeTestSuite) TestListBackups() {
+	home := copyTestData(s.T(), "validate")
+	cfg := &cosmovisor.Config{Home: home, Name: "dummyd", DataDir: filepath.Join(home, "data"), BackupFormat: cosmovisor.BackupTarGz}
+	s.Require().NoError(cosmovisor.BackupData(cfg, &cosmovisor.UpgradeInfo{Name: "chain2"}))
+	s.Require().NoError(os.MkdirAll(filepath.Join(cfg.BackupDir("chain3"), "data"), 0700))
+
+	backups, err := cosmovisor.ListBackups(cfg)
+	s.Require().NoError(err)
+	s.Require().Len(backups, 2)
+	byName := map[string]cosmovisor.BackupStatus{backups[0].Name: backups[0], backups[1].Name: backups[1]}
+	s.Require().True(byName["chain2"].Complete)
+	s.Require().Equal(cosmovisor.BackupTarGz, byName["chain2"].Format)
+	s.Require().Greater(byName["chain2"].Size, int64(0))
+	s.Require().False(byName["chain3"].Complete)
+	s.Require().Equal(cosmovisor.BackupCopy, byName["chain3"].Format)
+
+	manifest, err := cosmovisor.ReadManifest(cfg.BackupDir("chain2"))
+	s.Require().NoError(err)
+	s.Require().Equal(2, manifest.Files)
+	s.Require().Equal(int64(10), manifest.Bytes)
+	s.Require().Equal("chain2", manifest.Upgrade)
+}
+
+func (s *upgradeTestSuite) TestBackupDataHardlinkRetry() {
+	home := copyTestData(s.T(), "validate")
+	data := filepath.Join(home, "data")
+	cfg := &cosmovisor.Config{Home: home, Name: "dummyd", DataDir: data, BackupFormat: cosmovisor.BackupHardlink}
+	info := &cosmovisor.UpgradeInfo{Name: "chain2"}
+	files := map[string]string{
+		"state.db/000007.sst": "table 7",
+		"state.db/000008.sst": "table 8",
+		"state.db/CURRENT":    "MANIFEST-000009\n",
+	}
+	for file, content := range files {
+		s.Require().NoError(os.MkdirAll(filepath.Dir(filepath.Join(data, file)), 0755))
+		s.Require().NoError(ioutil.WriteFile(filepath.Join(data, file), []byte(content), 0644))
+	}
+	s.Require().NoError(os.Symlink("state.db", filepath.Join(data, "link")))
+	// a read-only dir still gets its files
+	s.Require().NoError(os.Chmod(filepath.Join(data, "state.db"), 0555))
+	defer os.Chmod(filepath.Join(data, "state.db"), 0755)
+
+	// the backup is interrupted before it is stamped, and retried into the same dir
+	s.Require().NoError(cosmovisor.BackupData(cfg, info))
+	s.Require().NoError(os.Remove(filepath.Join(cfg.BackupDir(info.Name), ".keep")))
+	defer os.Chmod(filepath.Join(cfg.BackupDir(info.Name), "data", "state.db"), 0755)
+	s.Require().NoError(cosmovisor.BackupData(cfg, info))
+	s.Require().FileExists(filepath.Join(cfg.BackupDir(info.Name), ".keep"))
+
+	// the live files are untouched, and still shared with the backup
+	for file, content := range files {
+		bz, err := ioutil.ReadFile(filepath.Join(data, file))
+		s.Require().NoError(err)
+		s.Require().Equal(content, string(bz), file)
+	}
+	s.Require().True(s.sameFile(filepath.Join(data, "state.db/000007.sst"), filepath.Join(cfg.BackupDir(info.Name), "data", "state.db/000007.sst")))
+	fi, err := os.Stat(filepath.Join(cfg.BackupDir(info.Name), "data", "state.db"))
+	s.Require().NoError(err)
+	s.Require().Equal(os.FileMode(0555), fi.Mode().Perm())
+	result, err := cosmovisor.VerifyBackup(cfg, info.Name)
+	s.Require().NoError(err)
+	s.Require().True(result.OK(), result.String())
+}
+
+// sameFile reports whether a and b are hardlinks of the same file
+func (s *upgradeTestSuite) sameFile(a, b string) bool {
+	fa, err := os.Stat(a)
+	s.Require().NoError(err)
+	fb, err := os.Stat(b)
+	s.Require().NoError(err)
+	return os.SameFile(fa, fb)
+}
+
 func (s *upgradeTestSuite) TestTouchFile() {
 	f, err := ioutil.TempFile("", "")
 	defer os.Remove(f.Name())
@@ -101,3 +308,158 @@ func (s *upgradeTestSuite) TestTouchFile() {
 	s.Require().NoError(err)
 	s.Require().Equal(touchTime, info.ModTime())
 }
+
+func (s *upgradeTestSuite) TestVerifyBackup() {
+	for _, format := range []cosmovisor.BackupFormat{cosmovisor.BackupCopy, cosmovisor.BackupTarZst} {
+		home := copyTestData(s.T(), "validate")
+		cfg := &cosmovisor.Config{Home: home, Name: "dummyd", DataDir: filepath.Join(home, "data"), BackupFormat: format}
+		s.Require().NoError(cosmovisor.BackupData(cfg, &cosmovisor.UpgradeInfo{Name: "chain2", Height: 123}))
+
+		manifest, err := cosmovisor.ReadManifest(cfg.BackupDir("chain2"))
+		s.Require().NoError(err)
+		s.Require().Equal(int64(123), manifest.Height)
+		s.Require().Equal(cfg.GenesisBin(), manifest.Binary)
+		s.Require().Len(manifest.BinarySHA256, 64)
+		for _, e := range manifest.Entries {
+			s.Require().Len(e.SHA256, 64, e.Path)
+			s.Require().NotEmpty(e.Mode, e.Path)
+		}
+
+		result, err := cosmovisor.VerifyBackup(cfg, "chain2")
+		s.Require().NoError(err)
+		s.Require().True(result.OK(), result.String())
+		s.Require().Equal(2, result.Files)
+
+		_, err = cosmovisor.VerifyBackup(cfg, "chain3")
+		s.Require().Error(err)
+	}
+
+	// tampering is only visible in a copied backup without extracting it
+	home := copyTestData(s.T(), "validate")
+	cfg := &cosmovisor.Config{Home: home, Name: "dummyd", DataDir: filepath.Join(home, "data")}
+	s.Require().NoError(cosmovisor.BackupData(cfg, &cosmovisor.UpgradeInfo{Name: "chain2"}))
+	data := filepath.Join(cfg.BackupDir("chain2"), "data")
+	// same size, different content
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(data, "modulesDir", "state.db"), []byte("tset\n"), 0644))
+	s.Require().NoError(os.Remove(filepath.Join(data, "application.db")))
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(data, "extra.db"), []byte("extra\n"), 0644))
+
+	result, err := cosmovisor.VerifyBackup(cfg, "chain2")
+	s.Require().NoError(err)
+	s.Require().False(result.OK())
+	s.Require().Equal([]string{"application.db"}, result.Missing)
+	s.Require().Equal([]string{"extra.db"}, result.Extra)
+	s.Require().Len(result.Corrupt, 1)
+	s.Require().Contains(result.Corrupt[0], "modulesDir/state.db has sha256")
+}
+
+func (s *upgradeTestSuite) TestBackupDataExcludes() {
+	for _, format := range []cosmovisor.BackupFormat{cosmovisor.BackupCopy, cosmovisor.BackupTarGz, cosmovisor.BackupHardlink} {
+		home := copyTestData(s.T(), "validate")
+		data := filepath.Join(home, "data")
+		cfg := &cosmovisor.Config{Home: home, Name: "dummyd", DataDir: data, BackupFormat: format,
+			BackupExclude: []string{"snapshots", "cs.wal"}, BackupInclude: []string{"snapshots/metadata.db", "priv_validator_state.json"}}
+		for _, file := range []string{"snapshots/1/chunk", "snapshots/metadata.db/000001.ldb", "cs.wal/wal"} {
+			s.Require().NoError(os.MkdirAll(filepath.Join(data, filepath.Dir(file)), 0755))
+			s.Require().NoError(ioutil.WriteFile(filepath.Join(data, file), []byte("regenerated\n"), 0644))
+		}
+		s.Require().NoError(cosmovisor.BackupData(cfg, &cosmovisor.UpgradeInfo{Name: "chain2"}))
+
+		manifest, err := cosmovisor.ReadManifest(cfg.BackupDir("chain2"))
+		s.Require().NoError(err)
+		s.Require().True(manifest.Partial, format)
+		s.Require().Equal(cfg.BackupExclude, manifest.Excluded)
+		s.Require().Equal(cfg.BackupInclude, manifest.Included)
+		s.Require().Equal(3, manifest.Files, format)
+		result, err := cosmovisor.VerifyBackup(cfg, "chain2")
+		s.Require().NoError(err)
+		s.Require().True(result.OK(), result.String())
+
+		backups, err := cosmovisor.ListBackups(cfg)
+		s.Require().NoError(err)
+		s.Require().True(backups[0].Partial)
+
+		_, err = cosmovisor.RestoreBackup(cfg, "chain2", false)
+		s.Require().NoError(err)
+		s.Require().FileExists(filepath.Join(data, "snapshots", "metadata.db", "000001.ldb"))
+		s.Require().FileExists(filepath.Join(data, "modulesDir", "state.db"))
+		// the excluded dirs are walked for the included name, but nothing in them is, so they aren't there at all
+		s.Require().NoDirExists(filepath.Join(data, "cs.wal"), format)
+		s.Require().NoDirExists(filepath.Join(data, "snapshots", "1"), format)
+	}
+}
+
+func (s *upgradeTestSuite) TestBackupExtraPaths() {
+	home := copyTestData(s.T(), "validate")
+	cfg := &cosmovisor.Config{Home: home, Name: "dummyd", DataDir: filepath.Join(home, "data"),
+		BackupExtraPaths: []string{"config", "keyring/missing"}}
+	appToml := filepath.Join(home, "config", "app.toml")
+	s.Require().NoError(os.MkdirAll(filepath.Dir(appToml), 0755))
+	s.Require().NoError(ioutil.WriteFile(appToml, []byte("pruning = \"default\"\n"), 0644))
+	s.Require().NoError(cosmovisor.BackupData(cfg, &cosmovisor.UpgradeInfo{Name: "chain2"}))
+
+	manifest, err := cosmovisor.ReadManifest(cfg.BackupDir("chain2"))
+	s.Require().NoError(err)
+	s.Require().Equal([]string{"config"}, manifest.ExtraPaths)
+	s.Require().Len(manifest.Extra, 1)
+	s.Require().Equal("config/app.toml", manifest.Extra[0].Path)
+	s.Require().FileExists(filepath.Join(cfg.BackupDir("chain2"), "home", "config", "app.toml"))
+	result, err := cosmovisor.VerifyBackup(cfg, "chain2")
+	s.Require().NoError(err)
+	s.Require().True(result.OK(), result.String())
+	s.Require().Equal(3, result.Files)
+
+	// the upgrade migrates the config, the restore puts the old one back
+	s.Require().NoError(ioutil.WriteFile(appToml, []byte("pruning = \"nothing\"\n"), 0644))
+	moved, err := cosmovisor.RestoreBackup(cfg, "chain2", false)
+	s.Require().NoError(err)
+	bz, err := ioutil.ReadFile(appToml)
+	s.Require().NoError(err)
+	s.Require().Equal("pruning = \"default\"\n", string(bz))
+	suffix := strings.TrimPrefix(moved, cfg.DataDir)
+	bz, err = ioutil.ReadFile(filepath.Join(home, "config"+suffix, "app.toml"))
+	s.Require().NoError(err)
+	s.Require().Equal("pruning = \"nothing\"\n", string(bz))
+
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(cfg.BackupDir("chain2"), "home", "config", "app.toml"), []byte("pruning = \"nothing\"\n"), 0644))
+	result, err = cosmovisor.VerifyBackup(cfg, "chain2")
+	s.Require().NoError(err)
+	s.Require().Len(result.Corrupt, 1)
+	s.Require().Contains(result.Corrupt[0], "home/config/app.toml has sha256")
+}
+
+func (s *upgradeTestSuite) TestRestoreBackupValidatorState() {
+	home := copyTestData(s.T(), "validate")
+	data := filepath.Join(home, "data")
+	cfg := &cosmovisor.Config{Home: home, Name: "dummyd", DataDir: data}
+	state := filepath.Join(data, "priv_validator_state.json")
+	writeState := func(height string, round, step int) {
+		s.Require().NoError(ioutil.WriteFile(state,
+			[]byte(fmt.Sprintf(`{"height": %q, "round": %d, "step": %d}`, height, round, step)), 0600))
+	}
+	writeState("100", 0, 3)
+	s.Require().NoError(cosmovisor.BackupData(cfg, &cosmovisor.UpgradeInfo{Name: "chain2"}))
+
+	// the same state is fine to restore
+	_, err := cosmovisor.RestoreBackup(cfg, "chain2", false)
+	s.Require().NoError(err)
+
+	// the validator signed after the backup, restoring it could double sign
+	writeState("100", 1, 1)
+	_, err = cosmovisor.RestoreBackup(cfg, "chain2", false)
+	s.Require().ErrorIs(err, cosmovisor.ErrValidatorStateBackwards)
+	s.Require().Contains(err.Error(), "is at height 100 round 1 step 1, the backup at height 100 round 0 step 3")
+	bz, err := ioutil.ReadFile(state)
+	s.Require().NoError(err)
+	s.Require().Contains(string(bz), `"round": 1`)
+	matches, err := filepath.Glob(data + ".replaced-*")
+	s.Require().NoError(err)
+	s.Require().Len(matches, 1, "only the first restore left a replaced data dir")
+
+	moved, err := cosmovisor.RestoreBackup(cfg, "chain2", true)
+	s.Require().NoError(err)
+	bz, err = ioutil.ReadFile(state)
+	s.Require().NoError(err)
+	s.Require().Contains(string(bz), `"round": 0`)
+	s.Require().FileExists(filepath.Join(moved, "priv_validator_state.json"))
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"text/tabwriter"
+	"time"
+
+	"github.com/provenance-io/cosmovisor"
+	"github.com/provenance-io/cosmovisor/version"
+)
+
+// command is a single cosmovisor sub-command
+type command struct {
+	run   func(args []string) error
+	name  string
+	usage string
+	short string
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{name: "run", usage: "run <args...>", short: "run the current daemon binary with args, upgrading as needed", run: runDaemon},
+		{name: "version", usage: "version [<args>]", short: "print the cosmovisor version, then run `<daemon> version <args>`", run: runVersion},
+		{name: "config", usage: "config", short: "print the resolved configuration as toml", run: runConfig},
+		{name: "status", usage: "status", short: "print the current upgrade and binary", run: runStatus},
+		{name: "list-upgrades", usage: "list-upgrades", short: "list the upgrades in the upgrades dir", run: runListUpgrades},
+		{name: "add-upgrade", usage: "add-upgrade [--force] <name> <path-to-binary>", short: "copy a binary into the upgrades dir", run: runAddUpgrade},
+		{name: "prepare", usage: "prepare <name> <info>", short: "download and verify the binary of an upgrade plan ahead of time", run: runPrepare},
+		{name: "rollback", usage: "rollback [--to <upgrade|genesis>] [--restore-data] [--force]", short: "point current back at an earlier upgrade, restoring its data backup", run: runRollback},
+		{name: "backup", usage: "backup list | backup restore [--force] <plan> | backup verify <plan>", short: "list, restore or verify the data backups taken before upgrades", run: runBackup},
+		{name: "prune", usage: "prune [--dry-run]", short: "remove backups beyond the retention limits and upgrade dirs that were upgraded past", run: runPrune},
+		{name: "help", usage: "help", short: "print this help", run: runHelp},
+	}
+}
+
+// findCommand returns the command with the given name, or nil if there is none
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func printUsage(w io.Writer, global *flag.FlagSet) {
+	fmt.Fprintf(w, "Usage: cosmovisor [flags] <command> [args...]\n\nCommands:\n")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.usage, cmd.short)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nAny other first argument is passed to the daemon as with `cosmovisor run`.\n")
+	fmt.Fprintf(w, "\nFlags (override env vars, which override the config file):\n")
+	global.SetOutput(w)
+	global.PrintDefaults()
+}
+
+// newFlagSet returns a flag set for the named command that reports errors instead of exiting
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	return fs
+}
+
+// noArgs returns an error if the command was given any arguments
+func noArgs(name string, args []string) error {
+	if len(args) != 0 {
+		return cosmovisor.WithExitCode(cosmovisor.ExitCodeUsage, fmt.Errorf("%s takes no arguments, got %d", name, len(args)))
+	}
+	return nil
+}
+
+func runDaemon(args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	return cosmovisor.NewSupervisor(cfg, os.Stdout, os.Stderr).Run(context.Background(), args)
+}
+
+// runVersion prints the cosmovisor version, then runs `<daemon> version <args>` with the current binary
+// so that `cosmovisor version` still shows the daemon version as it did before the sub-commands
+func runVersion(args []string) error {
+	fmt.Println(version.BuildInfo())
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	bin, err := cfg.CurrentBin()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(bin, append([]string{"version"}, args...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("running %s version: %w", cfg.Name, err)
+	}
+	return nil
+}
+
+func runConfig(args []string) error {
+	if err := noArgs("config", args); err != nil {
+		return err
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	file := cfg.ConfigFile()
+	if file == "" {
+		file = "none"
+	}
+	fmt.Printf("# Root: %s\n# Config file: %s\n", cfg.Root(), file)
+	return cfg.WriteTOML(os.Stdout)
+}
+
+func runStatus(args []string) error {
+	if err := noArgs("status", args); err != nil {
+		return err
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	current, err := cfg.CurrentUpgradeName()
+	if err != nil {
+		return err
+	}
+	bin := cfg.GenesisBin()
+	if current != "genesis" {
+		bin = cfg.UpgradeBin(current)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 1, ' ', 0)
+	fmt.Fprintf(tw, "Current upgrade:\t%s\n", current)
+	fmt.Fprintf(tw, "Current binary:\t%s\n", bin)
+	if err := cosmovisor.EnsureBinary(bin); err != nil {
+		fmt.Fprintf(tw, "Binary status:\tinvalid: %v\n", err)
+	} else {
+		fmt.Fprintf(tw, "Binary status:\tok\n")
+	}
+	return tw.Flush()
+}
+
+func runListUpgrades(args []string) error {
+	if err := noArgs("list-upgrades", args); err != nil {
+		return err
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	upgrades, err := cosmovisor.ListUpgrades(cfg)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "NAME\tCURRENT\tBINARY\n")
+	for _, up := range upgrades {
+		current := ""
+		if up.Current {
+			current = "*"
+		}
+		binStatus := "ok"
+		if up.BinErr != nil {
+			binStatus = up.BinErr.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", up.Name, current, binStatus)
+	}
+	return tw.Flush()
+}
+
+func runAddUpgrade(args []string) error {
+	fs := newFlagSet("add-upgrade")
+	force := fs.Bool("force", false, "overwrite an existing binary for this upgrade")
+	if err := fs.Parse(args); err != nil {
+		return cosmovisor.WithExitCode(cosmovisor.ExitCodeUsage, err)
+	}
+	if fs.NArg() != 2 {
+		return cosmovisor.WithExitCode(cosmovisor.ExitCodeUsage, errors.New("usage: cosmovisor add-upgrade [--force] <name> <path-to-binary>"))
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	name, src := fs.Arg(0), fs.Arg(1)
+	if err := cosmovisor.AddUpgrade(cfg, name, src, *force); err != nil {
+		return err
+	}
+	fmt.Printf("Added upgrade %s: %s\n", name, cfg.UpgradeBin(name))
+	return nil
+}
+
+func runPrepare(args []string) error {
+	if len(args) != 2 {
+		return cosmovisor.WithExitCode(cosmovisor.ExitCodeUsage, errors.New("usage: cosmovisor prepare <name> <info>"))
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	info := &cosmovisor.UpgradeInfo{Name: args[0], Info: args[1]}
+	if err := cosmovisor.PrepareUpgrade(cfg, info); err != nil {
+		return err
+	}
+	fmt.Printf("Prepared upgrade %s: %s\n", info.Name, cfg.UpgradeBin(info.Name))
+	return nil
+}
+
+func runRollback(args []string) error {
+	fs := newFlagSet("rollback")
+	to := fs.String("to", "", "the upgrade (or genesis) to roll back to, the one before the current upgrade by default")
+	restoreData := fs.Bool("restore-data", false, "move the data dir aside and restore the backup taken before the rolled back upgrade")
+	force := fs.Bool("force", false, "restore the data even if it moves the validator state backwards, which risks double signing")
+	if err := fs.Parse(args); err != nil {
+		return cosmovisor.WithExitCode(cosmovisor.ExitCodeUsage, err)
+	}
+	if fs.NArg() != 0 {
+		return cosmovisor.WithExitCode(cosmovisor.ExitCodeUsage, errors.New("usage: cosmovisor rollback [--to <upgrade|genesis>] [--restore-data] [--force]"))
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	result, err := cosmovisor.Rollback(cfg, *to, *restoreData, *force)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Rolled back from %s to %s\n", result.From, result.To)
+	if result.Backup != "" {
+		fmt.Printf("Restored the data backup of %s, the replaced data is in %s\n", result.Backup, result.MovedData)
+	}
+	return nil
+}
+
+func runBackup(args []string) error {
+	switch {
+	case len(args) == 1 && args[0] == "list":
+		return runBackupList()
+	case len(args) >= 2 && args[0] == "restore":
+		return runBackupRestore(args[1:])
+	case len(args) == 2 && args[0] == "verify":
+		return runBackupVerify(args[1])
+	default:
+		return cosmovisor.WithExitCode(cosmovisor.ExitCodeUsage,
+			errors.New("usage: cosmovisor backup list | cosmovisor backup restore [--force] <plan> | cosmovisor backup verify <plan>"))
+	}
+}
+
+func runBackupList() error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	backups, err := cosmovisor.ListBackups(cfg)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "NAME\tTAKEN\tSIZE\tFORMAT\tCOMPLETE\tPARTIAL\n")
+	for _, backup := range backups {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", backup.Name, backup.Taken.Format(time.RFC3339),
+			cosmovisor.HumanSize(backup.Size), backup.Format, yesNo(backup.Complete), yesNo(backup.Partial))
+	}
+	return tw.Flush()
+}
+
+// yesNo formats b for a table column
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func runBackupRestore(args []string) error {
+	fs := newFlagSet("backup restore")
+	force := fs.Bool("force", false, "restore even if it moves the validator state backwards, which risks double signing")
+	if err := fs.Parse(args); err != nil {
+		return cosmovisor.WithExitCode(cosmovisor.ExitCodeUsage, err)
+	}
+	if fs.NArg() != 1 {
+		return cosmovisor.WithExitCode(cosmovisor.ExitCodeUsage, errors.New("usage: cosmovisor backup restore [--force] <plan>"))
+	}
+	name := fs.Arg(0)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	moved, err := cosmovisor.RestoreBackup(cfg, name, *force)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored the data backup of %s into %s, the replaced data is in %s\n", name, cfg.DataDir, moved)
+	return nil
+}
+
+func runBackupVerify(name string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	result, err := cosmovisor.VerifyBackup(cfg, name)
+	if err != nil {
+		return err
+	}
+	if !result.OK() {
+		for _, path := range result.Missing {
+			fmt.Printf("missing %s\n", path)
+		}
+		for _, path := range result.Extra {
+			fmt.Printf("unexpected %s\n", path)
+		}
+		for _, diff := range result.Corrupt {
+			fmt.Printf("corrupt %s\n", diff)
+		}
+		return cosmovisor.WithExitCode(cosmovisor.ExitCodeBackup,
+			fmt.Errorf("backup of %s has %d missing, %d unexpected and %d corrupt files", name, len(result.Missing), len(result.Extra), len(result.Corrupt)))
+	}
+	fmt.Printf("Backup of %s is intact: %s\n", name, result)
+	return nil
+}
+
+func runPrune(args []string) error {
+	fs := newFlagSet("prune")
+	dryRun := fs.Bool("dry-run", false, "only list what would be removed")
+	if err := fs.Parse(args); err != nil {
+		return cosmovisor.WithExitCode(cosmovisor.ExitCodeUsage, err)
+	}
+	if err := noArgs("prune", fs.Args()); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	pruned, err := cosmovisor.Prune(cfg, *dryRun)
+	var total int64
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "KIND\tNAME\tSIZE\tREASON\n")
+	for _, item := range pruned {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", item.Kind, item.Name, cosmovisor.HumanSize(item.Size), item.Reason)
+		total += item.Size
+	}
+	tw.Flush()
+	if *dryRun {
+		fmt.Printf("Would free %s\n", cosmovisor.HumanSize(total))
+	} else {
+		fmt.Printf("Freed %s\n", cosmovisor.HumanSize(total))
+	}
+	return err
+}
+
+func runHelp(_ []string) error {
+	fs := newFlagSet("cosmovisor")
+	cosmovisor.AddConfigFlags(fs)
+	printUsage(os.Stdout, fs)
+	return nil
+}
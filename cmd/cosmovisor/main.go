@@ -1,14 +1,18 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 
-	"github.com/provenance-io/cosmovisor/version"
-
 	"github.com/provenance-io/cosmovisor"
+	"github.com/provenance-io/cosmovisor/version"
 )
 
+// overrides holds the config values set by global flags, they take precedence over env and config file
+var overrides = cosmovisor.ConfigOverrides{}
+
 func main() {
 	if os.Getenv("DAEMON_INFO") != "" {
 		fmt.Fprintf(os.Stderr, "%s\n", version.BuildInfo())
@@ -17,21 +21,38 @@ func main() {
 
 	if err := Run(os.Args[1:]); err != nil {
 		fmt.Fprintf(os.Stderr, "%+v\n", err)
-		os.Exit(1)
+		os.Exit(cosmovisor.ExitCode(err))
 	}
 }
 
-// Run is the main loop, but returns an error
+// Run parses the global flags and dispatches the rest of args to the matching sub-command, but returns an error.
+// The error carries the exit code, see cosmovisor.ExitCode.
+// For compatibility, anything that isn't a known sub-command is passed through to the daemon as with `run`.
 func Run(args []string) error {
-	cfg, err := cosmovisor.GetConfigFromEnv()
-	if err != nil {
-		return err
+	fs := newFlagSet("cosmovisor")
+	overrides = cosmovisor.AddConfigFlags(fs)
+	fs.Usage = func() { printUsage(os.Stderr, fs) }
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return cosmovisor.WithExitCode(cosmovisor.ExitCodeUsage, err)
 	}
+	args = fs.Args()
 
-	doUpgrade, err := cosmovisor.LaunchProcess(cfg, args, os.Stdout, os.Stderr)
-	// if RestartAfterUpgrade, we launch after a successful upgrade (only condition LaunchProcess returns nil)
-	for cfg.RestartAfterUpgrade && err == nil && doUpgrade {
-		doUpgrade, err = cosmovisor.LaunchProcess(cfg, args, os.Stdout, os.Stderr)
+	if len(args) == 0 {
+		printUsage(os.Stderr, fs)
+		return cosmovisor.WithExitCode(cosmovisor.ExitCodeUsage, errors.New("no command given"))
 	}
-	return err
+
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		return runDaemon(args)
+	}
+	return cmd.run(args[1:])
+}
+
+// loadConfig loads the config from the config file, env and global flags
+func loadConfig() (*cosmovisor.Config, error) {
+	return cosmovisor.LoadConfig(overrides)
 }
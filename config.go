@@ -0,0 +1,547 @@
+package cosmovisor
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	configFileName = "config.toml"
+	configFileEnv  = "DAEMON_CONFIG"
+	configFileFlag = "config"
+
+	sourceDefault = "default"
+)
+
+// ConfigOverrides holds config values set on the command line, keyed by config file key
+type ConfigOverrides map[string]string
+
+// fieldKind is the type of value a configField holds, used for parsing and for writing toml
+type fieldKind int
+
+const (
+	kindString fieldKind = iota
+	kindBool
+	kindInt
+	kindFloat
+	kindDuration
+	kindList
+	kindSize
+)
+
+// configField describes a single Config value and the places it can be set.
+// Every value can be set in the config file, overridden by an env var, and that by a flag.
+type configField struct {
+	set   func(cfg *Config, value string) error
+	get   func(cfg *Config) string
+	key   string
+	env   string
+	usage string
+	// def is the default value, applied before the config file
+	def  string
+	kind fieldKind
+}
+
+// configFields lists every Config value that can be configured
+var configFields = []*configField{
+	stringField("home", "DAEMON_HOME", "the node home dir, cosmovisor files live in <home>/cosmovisor",
+		func(cfg *Config) *string { return &cfg.Home }),
+	stringField("name", "DAEMON_NAME", "the name of the daemon binary",
+		func(cfg *Config) *string { return &cfg.Name }),
+	boolField("allow_download_binaries", "DAEMON_ALLOW_DOWNLOAD_BINARIES", "download upgrade binaries that are not present",
+		func(cfg *Config) *bool { return &cfg.AllowDownloadBinaries }),
+	boolField("require_checksum", "DAEMON_REQUIRE_CHECKSUM", "refuse to download binaries without a sha256 or sha512 checksum",
+		func(cfg *Config) *bool { return &cfg.RequireChecksum }),
+	stringField("trusted_keys", "DAEMON_TRUSTED_KEYS", "a file of minisign or ssh-ed25519 release keys, downloaded binaries must be signed by one of them",
+		func(cfg *Config) *string { return &cfg.TrustedKeys }),
+	boolField("restart_after_upgrade", "DAEMON_RESTART_AFTER_UPGRADE", "restart the daemon after a successful upgrade",
+		func(cfg *Config) *bool { return &cfg.RestartAfterUpgrade }),
+	stringField("backup_data_dir", "DAEMON_BACKUP_DATA_DIR", "the data dir to back up before each upgrade",
+		func(cfg *Config) *string { return &cfg.DataDir }),
+	stringField("backup_format", "DAEMON_BACKUP_FORMAT", "how the data dir is backed up: copy, hardlink, or streamed into a tar.zst or tar.gz archive",
+		func(cfg *Config) *string { return (*string)(&cfg.BackupFormat) }).withDefault(string(BackupCopy)),
+	listField("backup_immutable_patterns", "DAEMON_BACKUP_IMMUTABLE_PATTERNS", "file name patterns of data files that are never changed, the hardlink backup format links them",
+		func(cfg *Config) *[]string { return &cfg.ImmutablePatterns }).withDefault("*.sst,*.ldb"),
+	listField("backup_extra_paths", "DAEMON_BACKUP_EXTRA_PATHS", "files and dirs outside the data dir backed up with it, relative to the node home, eg. config",
+		func(cfg *Config) *[]string { return &cfg.BackupExtraPaths }),
+	listField("backup_exclude", "DAEMON_BACKUP_EXCLUDE", "patterns of data dir paths left out of backups, eg. snapshots,cs.wal, a name matches anywhere and a path from the data dir",
+		func(cfg *Config) *[]string { return &cfg.BackupExclude }),
+	listField("backup_include", "DAEMON_BACKUP_INCLUDE", "patterns of data dir paths backed up even though an exclude pattern matches them",
+		func(cfg *Config) *[]string { return &cfg.BackupInclude }),
+	intField("backup_workers", "DAEMON_BACKUP_WORKERS", "how many files are copied at once when data is copied for a backup or restore, 0 for one per CPU",
+		func(cfg *Config) *int { return &cfg.BackupWorkers }),
+	sizeField("backup_rate_limit", "DAEMON_BACKUP_RATE_LIMIT", "the most bytes per second copied for a backup or restore, eg. 100MB, 0 for no limit",
+		func(cfg *Config) *int64 { return &cfg.BackupRateLimit }),
+	intField("backup_keep", "DAEMON_BACKUP_KEEP", "how many backups to keep, older ones are removed after each backup, 0 to keep them all",
+		func(cfg *Config) *int { return &cfg.BackupKeep }),
+	sizeField("backup_max_size", "DAEMON_BACKUP_MAX_SIZE", "the most disk space backups may take, eg. 500GB, older ones are removed after each backup, 0 for no limit",
+		func(cfg *Config) *int64 { return &cfg.BackupMaxSize }),
+	stringField("disk_space_policy", "DAEMON_DISK_SPACE_POLICY", "what to do when the data backup won't fit on disk: fail, or skip-backup to upgrade without it",
+		func(cfg *Config) *string { return (*string)(&cfg.DiskSpacePolicy) }).withDefault(string(DiskSpaceFail)),
+	durationField("backup_max_age", "DAEMON_BACKUP_MAX_AGE", "how long backups are kept, older ones are removed after each backup, 0s to keep them forever",
+		func(cfg *Config) *time.Duration { return &cfg.BackupMaxAge }),
+	stringField("restart_policy", "DAEMON_RESTART_POLICY", "when to restart the daemon after it exits: never, on-failure or always",
+		func(cfg *Config) *string { return (*string)(&cfg.RestartPolicy) }).withDefault(string(RestartNever)),
+	durationField("restart_delay", "DAEMON_RESTART_DELAY", "how long to wait before the first restart",
+		func(cfg *Config) *time.Duration { return &cfg.RestartDelay }).withDefault("1s"),
+	floatField("restart_backoff", "DAEMON_RESTART_BACKOFF", "the restart delay is multiplied by this after each restart",
+		func(cfg *Config) *float64 { return &cfg.RestartBackoff }).withDefault("2"),
+	durationField("restart_max_delay", "DAEMON_RESTART_MAX_DELAY", "the longest delay between restarts",
+		func(cfg *Config) *time.Duration { return &cfg.RestartMaxDelay }).withDefault("1m"),
+	intField("restart_max_restarts", "DAEMON_RESTART_MAX_RESTARTS", "the most restarts allowed within the restart window before giving up, 0 for no limit",
+		func(cfg *Config) *int { return &cfg.RestartMaxRestarts }).withDefault("5"),
+	durationField("restart_window", "DAEMON_RESTART_WINDOW", "the window in which restarts are counted for crash loop detection",
+		func(cfg *Config) *time.Duration { return &cfg.RestartWindow }).withDefault("10m"),
+	stringField("shutdown_signal", "DAEMON_SHUTDOWN_SIGNAL", "the signal sent to stop the daemon for an upgrade, SIGTERM, SIGINT or SIGKILL",
+		func(cfg *Config) *string { return &cfg.ShutdownSignal }).withDefault("SIGTERM"),
+	durationField("shutdown_grace", "DAEMON_SHUTDOWN_GRACE", "how long the daemon has to exit after the shutdown signal before it is killed",
+		func(cfg *Config) *time.Duration { return &cfg.ShutdownGrace }).withDefault("30s"),
+	listField("forward_signals", "DAEMON_FORWARD_SIGNALS", "the signals passed on to the daemon",
+		func(cfg *Config) *[]string { return &cfg.ForwardSignals }).withDefault("SIGHUP,SIGINT,SIGQUIT,SIGTERM,SIGUSR1,SIGUSR2"),
+	listField("upgrade_detectors", "DAEMON_UPGRADE_DETECTORS", "how upgrades are found in the daemon output: plain, json, logfmt and regex",
+		func(cfg *Config) *[]string { return &cfg.UpgradeDetectors }).withDefault("plain,json"),
+	stringField("upgrade_regex", "DAEMON_UPGRADE_REGEX", "the regexp of the regex detector, with a (?P<name>...) group and optional height, time and info groups",
+		func(cfg *Config) *string { return &cfg.UpgradeRegex }),
+	boolField("watch_upgrade_info", "DAEMON_WATCH_UPGRADE_INFO", "also detect upgrades by watching the upgrade-info.json the node writes into its data dir",
+		func(cfg *Config) *bool { return &cfg.WatchUpgradeInfo }),
+	durationField("poll_interval", "DAEMON_POLL_INTERVAL", "how often upgrade-info.json is checked",
+		func(cfg *Config) *time.Duration { return &cfg.PollInterval }).withDefault("300ms"),
+	stringField("plan_endpoint", "DAEMON_PLAN_ENDPOINT", "the node's REST endpoint to poll for the upgrade plan, eg. http://localhost:1317, empty to not poll",
+		func(cfg *Config) *string { return &cfg.PlanEndpoint }),
+	durationField("plan_poll_interval", "DAEMON_PLAN_POLL_INTERVAL", "how often the REST endpoint is polled for the upgrade plan",
+		func(cfg *Config) *time.Duration { return &cfg.PlanPollInterval }).withDefault("30s"),
+}
+
+// withDefault sets the default value of the field
+func (f *configField) withDefault(def string) *configField {
+	f.def = def
+	return f
+}
+
+func stringField(key, env, usage string, ptr func(cfg *Config) *string) *configField {
+	return &configField{
+		key:   key,
+		env:   env,
+		usage: usage,
+		kind:  kindString,
+		set: func(cfg *Config, value string) error {
+			*ptr(cfg) = value
+			return nil
+		},
+		get: func(cfg *Config) string { return *ptr(cfg) },
+	}
+}
+
+func boolField(key, env, usage string, ptr func(cfg *Config) *bool) *configField {
+	return &configField{
+		key:   key,
+		env:   env,
+		usage: usage,
+		kind:  kindBool,
+		set: func(cfg *Config, value string) error {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("expected true or false, got %q", value)
+			}
+			*ptr(cfg) = b
+			return nil
+		},
+		get: func(cfg *Config) string { return strconv.FormatBool(*ptr(cfg)) },
+	}
+}
+
+func intField(key, env, usage string, ptr func(cfg *Config) *int) *configField {
+	return &configField{
+		key:   key,
+		env:   env,
+		usage: usage,
+		kind:  kindInt,
+		set: func(cfg *Config, value string) error {
+			i, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("expected an integer, got %q", value)
+			}
+			*ptr(cfg) = i
+			return nil
+		},
+		get: func(cfg *Config) string { return strconv.Itoa(*ptr(cfg)) },
+	}
+}
+
+func floatField(key, env, usage string, ptr func(cfg *Config) *float64) *configField {
+	return &configField{
+		key:   key,
+		env:   env,
+		usage: usage,
+		kind:  kindFloat,
+		set: func(cfg *Config, value string) error {
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("expected a number, got %q", value)
+			}
+			*ptr(cfg) = f
+			return nil
+		},
+		get: func(cfg *Config) string { return strconv.FormatFloat(*ptr(cfg), 'f', -1, 64) },
+	}
+}
+
+func durationField(key, env, usage string, ptr func(cfg *Config) *time.Duration) *configField {
+	return &configField{
+		key:   key,
+		env:   env,
+		usage: usage,
+		kind:  kindDuration,
+		set: func(cfg *Config, value string) error {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("expected a duration like 30s or 5m, got %q", value)
+			}
+			*ptr(cfg) = d
+			return nil
+		},
+		get: func(cfg *Config) string { return ptr(cfg).String() },
+	}
+}
+
+// sizeField is a number of bytes, with an optional unit like 500MB or 2GiB
+func sizeField(key, env, usage string, ptr func(cfg *Config) *int64) *configField {
+	return &configField{
+		key:   key,
+		env:   env,
+		usage: usage,
+		kind:  kindSize,
+		set: func(cfg *Config, value string) error {
+			n, err := parseSize(value)
+			if err != nil {
+				return err
+			}
+			*ptr(cfg) = n
+			return nil
+		},
+		get: func(cfg *Config) string { return formatSize(*ptr(cfg)) },
+	}
+}
+
+// sizeUnits are the units a size can have, largest first
+var sizeUnits = []struct {
+	name string
+	n    int64
+}{
+	{"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10},
+	{"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3}, {"B", 1},
+}
+
+// parseSize parses a number of bytes, with an optional unit like 500MB or 1.5GiB
+func parseSize(value string) (int64, error) {
+	s := strings.TrimSpace(value)
+	mult := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(strings.ToUpper(s), strings.ToUpper(unit.name)) {
+			s, mult = strings.TrimSpace(s[:len(s)-len(unit.name)]), unit.n
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("expected a size like 500MB or 2GiB, got %q", value)
+	}
+	return int64(f * float64(mult)), nil
+}
+
+// formatSize formats a number of bytes in the largest unit that represents it exactly
+func formatSize(n int64) string {
+	for _, unit := range sizeUnits {
+		if n != 0 && unit.n > 1 && n%unit.n == 0 {
+			return fmt.Sprintf("%d%s", n/unit.n, unit.name)
+		}
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// listField is a comma separated list in env vars and flags, and an array in the config file
+func listField(key, env, usage string, ptr func(cfg *Config) *[]string) *configField {
+	return &configField{
+		key:   key,
+		env:   env,
+		usage: usage,
+		kind:  kindList,
+		set: func(cfg *Config, value string) error {
+			var list []string
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+			*ptr(cfg) = list
+			return nil
+		},
+		get: func(cfg *Config) string { return strings.Join(*ptr(cfg), ",") },
+	}
+}
+
+// zero is the value that sets the field to the zero value of its kind
+func (f *configField) zero() string {
+	switch f.kind {
+	case kindBool:
+		return "false"
+	case kindInt, kindFloat, kindSize:
+		return "0"
+	case kindDuration:
+		return "0s"
+	default:
+		return ""
+	}
+}
+
+// flagName is the command line flag for this field, eg. --allow-download-binaries
+func (f *configField) flagName() string {
+	return strings.ReplaceAll(f.key, "_", "-")
+}
+
+// findConfigField returns the field with the given config file key, or nil
+func findConfigField(key string) *configField {
+	for _, f := range configFields {
+		if f.key == key {
+			return f
+		}
+	}
+	return nil
+}
+
+// overrideValue is a flag.Value that only records a value when the flag is actually set
+type overrideValue struct {
+	overrides ConfigOverrides
+	key       string
+	isBool    bool
+}
+
+func (v overrideValue) String() string     { return "" }
+func (v overrideValue) IsBoolFlag() bool   { return v.isBool }
+func (v overrideValue) Set(s string) error { v.overrides[v.key] = s; return nil }
+
+// AddConfigFlags registers a flag for every config value (and --config for the file itself) on fs.
+// Once fs is parsed, the returned overrides contain only the flags that were set.
+func AddConfigFlags(fs *flag.FlagSet) ConfigOverrides {
+	overrides := ConfigOverrides{}
+	fs.Var(overrideValue{overrides: overrides, key: configFileFlag}, configFileFlag,
+		fmt.Sprintf("the config file to load (default <home>/%s/%s)", rootName, configFileName))
+	for _, f := range configFields {
+		usage := fmt.Sprintf("%s (%s)", f.usage, f.env)
+		if f.def != "" {
+			usage = fmt.Sprintf("%s (%s, default %s)", f.usage, f.env, f.def)
+		}
+		fs.Var(overrideValue{overrides: overrides, key: f.key, isBool: f.kind == kindBool}, f.flagName(), usage)
+	}
+	return overrides
+}
+
+// LoadConfig builds a config from the config file, then the environment, then the given overrides,
+// each one taking precedence over the one before. It then validates the result.
+//
+// The config file is the --config override, or $DAEMON_CONFIG, or <home>/cosmovisor/config.toml if it exists.
+// Errors map to ExitCodeConfig.
+func LoadConfig(overrides ConfigOverrides) (*Config, error) {
+	cfg, err := loadConfig(overrides)
+	return cfg, WithExitCode(ExitCodeConfig, err)
+}
+
+func loadConfig(overrides ConfigOverrides) (*Config, error) {
+	cfg := &Config{sources: map[string]string{}}
+	for _, f := range configFields {
+		if f.def != "" {
+			if err := f.set(cfg, f.def); err != nil {
+				return nil, fmt.Errorf("invalid default for %s: %w", f.env, err)
+			}
+		}
+	}
+
+	file, required := overrides[configFileFlag], true
+	if file == "" {
+		file = os.Getenv(configFileEnv)
+	}
+	if file == "" {
+		// we need to know home before we can find the default config file
+		home := overrides["home"]
+		if home == "" {
+			home = os.Getenv("DAEMON_HOME")
+		}
+		if home != "" {
+			file, required = filepath.Join(home, rootName, configFileName), false
+		}
+	}
+
+	if file != "" {
+		if err := cfg.loadFile(file, required); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range configFields {
+		// a var set to nothing still counts, so DAEMON_X= puts back the default over what the file set
+		if value, ok := os.LookupEnv(f.env); ok {
+			if err := cfg.setField(f, value, "env "+f.env); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for key, value := range overrides {
+		if key == configFileFlag {
+			continue
+		}
+		f := findConfigField(key)
+		if f == nil {
+			return nil, fmt.Errorf("unknown config override %q", key)
+		}
+		if err := cfg.setField(f, value, "flag --"+f.flagName()); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// loadFile reads the toml config file into cfg. A missing file is only an error if required.
+func (cfg *Config) loadFile(file string, required bool) error {
+	values := map[string]interface{}{}
+	if _, err := toml.DecodeFile(file, &values); err != nil {
+		if os.IsNotExist(err) && !required {
+			return nil
+		}
+		return fmt.Errorf("reading config file %s: %w", file, err)
+	}
+	cfg.configFile = file
+
+	// sorted, so errors are reported consistently
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		f := findConfigField(key)
+		if f == nil {
+			return fmt.Errorf("unknown key %q in config file %s", key, file)
+		}
+		value, err := tomlValueString(values[key])
+		if err != nil {
+			return fmt.Errorf("invalid %s in config file %s: %w", key, file, err)
+		}
+		if err := cfg.setField(f, value, "file "+file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tomlValueString converts a decoded toml value into the string form used by env vars and flags
+func tomlValueString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			str, ok := item.(string)
+			if !ok {
+				return "", fmt.Errorf("expected a list of strings, got %T in it", item)
+			}
+			if strings.Contains(str, ",") {
+				return "", fmt.Errorf("list items cannot contain a comma: %q", str)
+			}
+			items[i] = str
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value type %T", value)
+	}
+}
+
+// setField sets a single field on cfg and records where the value came from.
+// An empty value puts the field back to its default, or the zero value of its kind if it has none,
+// required fields without a default are then refused by validate.
+func (cfg *Config) setField(f *configField, value, source string) error {
+	if strings.TrimSpace(value) == "" {
+		value = f.def
+		if value == "" {
+			value = f.zero()
+		}
+	}
+	if err := f.set(cfg, value); err != nil {
+		return fmt.Errorf("invalid %s (from %s): %w", f.env, source, err)
+	}
+	if cfg.sources == nil {
+		cfg.sources = map[string]string{}
+	}
+	cfg.sources[f.key] = source
+	return nil
+}
+
+// Source returns where the value for the given config file key was set,
+// eg. "env DAEMON_HOME", "file /home/node/cosmovisor/config.toml", "flag --home" or "default".
+func (cfg *Config) Source(key string) string {
+	if source, ok := cfg.sources[key]; ok {
+		return source
+	}
+	return sourceDefault
+}
+
+// ConfigFile returns the path of the config file that was loaded, or "" if there was none
+func (cfg *Config) ConfigFile() string {
+	return cfg.configFile
+}
+
+// describe names the setting for the given key in errors, including where it was set if not obvious
+func (cfg *Config) describe(key string) string {
+	f := findConfigField(key)
+	source := cfg.Source(key)
+	if source == sourceDefault || source == "env "+f.env {
+		return f.env
+	}
+	return fmt.Sprintf("%s (from %s)", f.env, source)
+}
+
+// WriteTOML writes the config as a toml config file, noting where each value was set
+func (cfg *Config) WriteTOML(w io.Writer) error {
+	for _, f := range configFields {
+		value := f.get(cfg)
+		switch f.kind {
+		case kindString, kindDuration, kindSize:
+			value = strconv.Quote(value)
+		case kindList:
+			var items []string
+			if value != "" {
+				items = strings.Split(value, ",")
+			}
+			for i := range items {
+				items[i] = strconv.Quote(items[i])
+			}
+			value = "[" + strings.Join(items, ", ") + "]"
+		}
+		if _, err := fmt.Fprintf(w, "%s = %s # %s, %s\n", f.key, value, f.env, cfg.Source(f.key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,330 @@
+package cosmovisor
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// clearConfigEnv makes sure no DAEMON_* vars from the environment leak into a test.
+// They are unset rather than emptied, as an empty var overrides the config file.
+func (s *argsTestSuite) clearConfigEnv() {
+	s.T().Setenv(configFileEnv, "")
+	os.Unsetenv(configFileEnv)
+	for _, f := range configFields {
+		s.T().Setenv(f.env, "")
+		os.Unsetenv(f.env)
+	}
+}
+
+// writeConfigFile writes a config.toml into home/cosmovisor and returns its path
+func (s *argsTestSuite) writeConfigFile(home, content string) string {
+	file := filepath.Join(home, rootName, configFileName)
+	s.Require().NoError(os.WriteFile(file, []byte(content), 0600))
+	return file
+}
+
+func (s *argsTestSuite) TestLoadConfigPrecedence() {
+	s.clearConfigEnv()
+	home := s.T().TempDir()
+	s.Require().NoError(os.Mkdir(filepath.Join(home, rootName), 0755))
+	file := s.writeConfigFile(home, `
+name = "filed"
+allow_download_binaries = true
+restart_after_upgrade = true
+plan_endpoint = "http://localhost:1317"
+restart_delay = "5s"
+`)
+
+	// file only
+	s.T().Setenv("DAEMON_HOME", home)
+	cfg, err := LoadConfig(nil)
+	s.Require().NoError(err)
+	s.Require().Equal(file, cfg.ConfigFile())
+	s.Require().Equal("filed", cfg.Name)
+	s.Require().True(cfg.AllowDownloadBinaries)
+	s.Require().True(cfg.RestartAfterUpgrade)
+	s.Require().Equal("file "+file, cfg.Source("name"))
+	s.Require().Equal("env DAEMON_HOME", cfg.Source("home"))
+	s.Require().Equal("default", cfg.Source("backup_data_dir"))
+
+	// env overrides file
+	s.T().Setenv("DAEMON_NAME", "envd")
+	s.T().Setenv("DAEMON_RESTART_AFTER_UPGRADE", "false")
+	cfg, err = LoadConfig(nil)
+	s.Require().NoError(err)
+	s.Require().Equal("envd", cfg.Name)
+	s.Require().False(cfg.RestartAfterUpgrade)
+	s.Require().True(cfg.AllowDownloadBinaries)
+	s.Require().Equal("env DAEMON_NAME", cfg.Source("name"))
+
+	// an empty env var puts back the default over what the file set
+	for _, env := range []string{"DAEMON_ALLOW_DOWNLOAD_BINARIES", "DAEMON_PLAN_ENDPOINT", "DAEMON_RESTART_DELAY"} {
+		s.T().Setenv(env, "")
+	}
+	cfg, err = LoadConfig(nil)
+	s.Require().NoError(err)
+	s.Require().False(cfg.AllowDownloadBinaries)
+	s.Require().Equal("", cfg.PlanEndpoint)
+	s.Require().Equal(time.Second, cfg.RestartDelay)
+	s.Require().Equal("env DAEMON_PLAN_ENDPOINT", cfg.Source("plan_endpoint"))
+	for _, env := range []string{"DAEMON_ALLOW_DOWNLOAD_BINARIES", "DAEMON_PLAN_ENDPOINT", "DAEMON_RESTART_DELAY"} {
+		os.Unsetenv(env)
+	}
+
+	// flags override both
+	cfg, err = LoadConfig(ConfigOverrides{"name": "flagd", "allow_download_binaries": "false"})
+	s.Require().NoError(err)
+	s.Require().Equal("flagd", cfg.Name)
+	s.Require().False(cfg.AllowDownloadBinaries)
+	s.Require().Equal("flag --name", cfg.Source("name"))
+	s.Require().Equal("flag --allow-download-binaries", cfg.Source("allow_download_binaries"))
+}
+
+func (s *argsTestSuite) TestLoadConfigEmptyEnv() {
+	s.clearConfigEnv()
+	home := s.T().TempDir()
+	s.Require().NoError(os.Mkdir(filepath.Join(home, rootName), 0755))
+	s.writeConfigFile(home, `
+home = "`+home+`"
+name = "filed"
+shutdown_grace = "5s"
+shutdown_signal = "SIGINT"
+restart_window = "1h"
+plan_endpoint = "http://localhost:1317"
+`)
+
+	cases := map[string]struct {
+		key    string
+		check  func(cfg *Config)
+		errMsg string
+	}{
+		"duration with a default": {
+			key:   "shutdown_grace",
+			check: func(cfg *Config) { s.Require().Equal(30*time.Second, cfg.ShutdownGrace) },
+		},
+		"window with a default": {
+			key:   "restart_window",
+			check: func(cfg *Config) { s.Require().Equal(10*time.Minute, cfg.RestartWindow) },
+		},
+		"signal with a default": {
+			key:   "shutdown_signal",
+			check: func(cfg *Config) { s.Require().Equal("SIGTERM", cfg.ShutdownSignal) },
+		},
+		"string without a default": {
+			key:   "plan_endpoint",
+			check: func(cfg *Config) { s.Require().Equal("", cfg.PlanEndpoint) },
+		},
+		"required string": {
+			key:    "name",
+			errMsg: "DAEMON_NAME is not set",
+		},
+	}
+	for name, tc := range cases {
+		s.Run(name, func() {
+			env := findConfigField(tc.key).env
+			s.T().Setenv(env, "")
+			cfg, err := LoadConfig(ConfigOverrides{"config": filepath.Join(home, rootName, configFileName)})
+			if tc.errMsg != "" {
+				s.Require().Error(err)
+				s.Require().Contains(err.Error(), tc.errMsg)
+				return
+			}
+			s.Require().NoError(err)
+			tc.check(cfg)
+			s.Require().Equal("env "+env, cfg.Source(tc.key))
+		})
+	}
+}
+
+func (s *argsTestSuite) TestLoadConfigFile() {
+	s.clearConfigEnv()
+	home := s.T().TempDir()
+	s.Require().NoError(os.Mkdir(filepath.Join(home, rootName), 0755))
+
+	// a missing default config file is fine
+	cfg, err := LoadConfig(ConfigOverrides{"home": home, "name": "flagd"})
+	s.Require().NoError(err)
+	s.Require().Equal("", cfg.ConfigFile())
+
+	// but an explicitly requested one is not
+	_, err = LoadConfig(ConfigOverrides{"config": filepath.Join(home, "missing.toml"), "home": home, "name": "flagd"})
+	s.Require().Error(err)
+
+	// home can come from an explicit config file
+	other := filepath.Join(s.T().TempDir(), "other.toml")
+	s.Require().NoError(os.WriteFile(other, []byte("home = \""+home+"\"\nname = \"otherd\"\n"), 0600))
+	s.T().Setenv(configFileEnv, other)
+	cfg, err = LoadConfig(nil)
+	s.Require().NoError(err)
+	s.Require().Equal(home, cfg.Home)
+	s.Require().Equal("otherd", cfg.Name)
+	s.Require().Equal(other, cfg.ConfigFile())
+}
+
+func (s *argsTestSuite) TestLoadConfigErrorSources() {
+	s.clearConfigEnv()
+	home := s.T().TempDir()
+	s.Require().NoError(os.Mkdir(filepath.Join(home, rootName), 0755))
+	s.T().Setenv("DAEMON_HOME", home)
+
+	cases := map[string]struct {
+		file      string
+		env       map[string]string
+		overrides ConfigOverrides
+		errMsg    string
+	}{
+		"unknown key": {
+			file:   "name = \"d\"\nno_such_key = 1\n",
+			errMsg: `unknown key "no_such_key"`,
+		},
+		"bad bool in file": {
+			file:   "name = \"d\"\nallow_download_binaries = \"maybe\"\n",
+			errMsg: "invalid DAEMON_ALLOW_DOWNLOAD_BINARIES (from file ",
+		},
+		"bad bool in env": {
+			file:   "name = \"d\"\n",
+			env:    map[string]string{"DAEMON_RESTART_AFTER_UPGRADE": "yes please"},
+			errMsg: "invalid DAEMON_RESTART_AFTER_UPGRADE (from env DAEMON_RESTART_AFTER_UPGRADE)",
+		},
+		"relative data dir in file": {
+			file:   "name = \"d\"\nbackup_data_dir = \"data\"\n",
+			errMsg: "DAEMON_BACKUP_DATA_DIR (from file ",
+		},
+		"relative data dir in flag": {
+			file:      "name = \"d\"\n",
+			overrides: ConfigOverrides{"backup_data_dir": "data"},
+			errMsg:    "DAEMON_BACKUP_DATA_DIR (from flag --backup-data-dir) must be an absolute path",
+		},
+		"bad restart policy": {
+			file:   "name = \"d\"\nrestart_policy = \"sometimes\"\n",
+			errMsg: "DAEMON_RESTART_POLICY (from file ",
+		},
+		"bad backup format": {
+			file:   "name = \"d\"\n",
+			env:    map[string]string{"DAEMON_BACKUP_FORMAT": "tar.xz"},
+			errMsg: "DAEMON_BACKUP_FORMAT must be one of copy, tar.zst, tar.gz or hardlink, got \"tar.xz\"",
+		},
+		"extra path outside home": {
+			file:   "name = \"d\"\nbackup_extra_paths = [\"config\", \"../other\"]\n",
+			errMsg: "DAEMON_BACKUP_EXTRA_PATHS (from file ",
+		},
+		"negative backup keep": {
+			file:   "name = \"d\"\nbackup_keep = -1\n",
+			errMsg: "DAEMON_BACKUP_KEEP (from file ",
+		},
+		"negative backup max age": {
+			file:   "name = \"d\"\n",
+			env:    map[string]string{"DAEMON_BACKUP_MAX_AGE": "-1h"},
+			errMsg: "DAEMON_BACKUP_MAX_AGE cannot be negative",
+		},
+		"negative backup workers": {
+			file:   "name = \"d\"\nbackup_workers = -1\n",
+			errMsg: "DAEMON_BACKUP_WORKERS (from file ",
+		},
+		"negative restart delay": {
+			file:   "name = \"d\"\nrestart_delay = \"-1s\"\n",
+			errMsg: "DAEMON_RESTART_DELAY (from file ",
+		},
+		"negative restart max delay": {
+			file:      "name = \"d\"\n",
+			overrides: ConfigOverrides{"restart_max_delay": "-1m"},
+			errMsg:    "DAEMON_RESTART_MAX_DELAY (from flag --restart-max-delay) cannot be negative",
+		},
+		"negative restart window": {
+			file:   "name = \"d\"\n",
+			env:    map[string]string{"DAEMON_RESTART_WINDOW": "-10m"},
+			errMsg: "DAEMON_RESTART_WINDOW cannot be negative",
+		},
+		"bad duration in env": {
+			file:   "name = \"d\"\n",
+			env:    map[string]string{"DAEMON_RESTART_DELAY": "10"},
+			errMsg: "invalid DAEMON_RESTART_DELAY (from env DAEMON_RESTART_DELAY)",
+		},
+		"bad shutdown signal": {
+			file:      "name = \"d\"\n",
+			overrides: ConfigOverrides{"shutdown_signal": "SIGWHATEVER"},
+			errMsg:    "invalid DAEMON_SHUTDOWN_SIGNAL (from flag --shutdown-signal)",
+		},
+		"shutdown signal that does not stop": {
+			file:   "name = \"d\"\nshutdown_signal = \"SIGHUP\"\n",
+			errMsg: "SIGHUP does not stop the daemon",
+		},
+		"bad upgrade regex": {
+			file:   "name = \"d\"\nupgrade_detectors = [\"regex\"]\nupgrade_regex = \"halt (\\\\S+)\"\n",
+			errMsg: "invalid DAEMON_UPGRADE_REGEX (from file ",
+		},
+		"unknown detector": {
+			file:      "name = \"d\"\n",
+			overrides: ConfigOverrides{"upgrade_detectors": "plain,xml"},
+			errMsg:    "invalid DAEMON_UPGRADE_DETECTORS (from flag --upgrade-detectors)",
+		},
+		"unknown override": {
+			file:      "name = \"d\"\n",
+			overrides: ConfigOverrides{"nope": "1"},
+			errMsg:    `unknown config override "nope"`,
+		},
+	}
+
+	for name, tc := range cases {
+		s.Run(name, func() {
+			s.writeConfigFile(home, tc.file)
+			for key, value := range tc.env {
+				s.T().Setenv(key, value)
+			}
+			_, err := LoadConfig(tc.overrides)
+			s.Require().Error(err)
+			s.Require().Contains(err.Error(), tc.errMsg)
+		})
+	}
+}
+
+func (s *argsTestSuite) TestWriteTOMLRoundTrip() {
+	s.clearConfigEnv()
+	home := s.T().TempDir()
+	s.Require().NoError(os.Mkdir(filepath.Join(home, rootName), 0755))
+
+	cfg, err := LoadConfig(ConfigOverrides{
+		"home": home, "name": "tripd", "restart_after_upgrade": "true", "backup_data_dir": home,
+		"restart_policy": "on-failure", "restart_delay": "1m30s", "restart_backoff": "1.5",
+		"backup_max_size": "1.5GiB", "backup_keep": "3",
+	})
+	s.Require().NoError(err)
+
+	var buf bytes.Buffer
+	s.Require().NoError(cfg.WriteTOML(&buf))
+	file := s.writeConfigFile(home, buf.String())
+
+	loaded, err := LoadConfig(ConfigOverrides{"config": file})
+	s.Require().NoError(err)
+	for _, f := range configFields {
+		s.Require().Equal(f.get(cfg), f.get(loaded), f.key)
+		s.Require().Equal("file "+file, loaded.Source(f.key), f.key)
+	}
+}
+
+func (s *argsTestSuite) TestParseSize() {
+	cases := map[string]int64{
+		"0":      0,
+		"1234":   1234,
+		"500MB":  500e6,
+		"2 GiB":  2 << 30,
+		"1.5gib": 3 << 29,
+		"10kb":   10e3,
+		"3TB":    3e12,
+	}
+	for value, expected := range cases {
+		n, err := parseSize(value)
+		s.Require().NoError(err, value)
+		s.Require().Equal(expected, n, value)
+		back, err := parseSize(formatSize(n))
+		s.Require().NoError(err, value)
+		s.Require().Equal(n, back, value)
+	}
+
+	for _, value := range []string{"", "GB", "-1GB", "5 parsecs"} {
+		_, err := parseSize(value)
+		s.Require().Error(err, value)
+	}
+}
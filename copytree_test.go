@@ -0,0 +1,141 @@
+package cosmovisor
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeDataDir fills dir with files of size bytes, spread over a few dirs like a node's databases
+func writeDataDir(t testing.TB, dir string, files, size int) {
+	content := bytes.Repeat([]byte("x"), size)
+	for i := 0; i < files; i++ {
+		sub := filepath.Join(dir, fmt.Sprintf("db%d.db", i%4))
+		require.NoError(t, os.MkdirAll(sub, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(sub, fmt.Sprintf("%06d.sst", i)), content, 0644))
+	}
+}
+
+func TestCopyTree(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "data")
+	writeDataDir(t, src, 20, 1000)
+	require.NoError(t, os.WriteFile(filepath.Join(src, "priv"), []byte("secret\n"), 0600))
+	require.NoError(t, os.Symlink("db0.db", filepath.Join(src, "link")))
+	require.NoError(t, os.Chmod(filepath.Join(src, "db1.db"), 0555))
+	t.Cleanup(func() { os.Chmod(filepath.Join(src, "db1.db"), 0755) })
+
+	dst := filepath.Join(t.TempDir(), "data")
+	require.NoError(t, copyTree(src, dst, copyOptions{workers: 4}))
+	t.Cleanup(func() { os.Chmod(filepath.Join(dst, "db1.db"), 0755) })
+
+	want, err := buildManifest(src, true, nil)
+	require.NoError(t, err)
+	got, err := buildManifest(dst, true, nil)
+	require.NoError(t, err)
+	r := want.compare(got)
+	require.True(t, r.OK(), r.String())
+	require.Equal(t, 21, r.Files)
+
+	link, err := os.Readlink(filepath.Join(dst, "link"))
+	require.NoError(t, err)
+	require.Equal(t, "db0.db", link)
+	info, err := os.Stat(filepath.Join(dst, "db1.db"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0555), info.Mode().Perm())
+
+	// a failed copy says which file
+	dst = filepath.Join(t.TempDir(), "data")
+	require.NoError(t, os.MkdirAll(filepath.Join(dst, "priv", "in-the-way"), 0755))
+	err = copyTree(src, dst, copyOptions{workers: 4})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "priv")
+}
+
+func TestCopyTreeRecordsWrittenFiles(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "data")
+	writeDataDir(t, src, 8, 1000)
+	require.NoError(t, os.WriteFile(filepath.Join(src, "priv"), []byte("secret\n"), 0600))
+	want, err := buildManifest(src, true, nil)
+	require.NoError(t, err)
+
+	// each way of backing up records what it wrote, as a manifest of the result would list it
+	check := func(record *manifestRecorder) {
+		got := &Manifest{}
+		for _, e := range record.sorted() {
+			got.add(e)
+		}
+		require.Equal(t, want, got)
+	}
+	record := &manifestRecorder{}
+	require.NoError(t, copyTree(src, filepath.Join(t.TempDir(), "data"), copyOptions{workers: 4, record: record}))
+	check(record)
+
+	record = &manifestRecorder{}
+	archive := filepath.Join(t.TempDir(), "data.tar.zst")
+	require.NoError(t, writeArchive(src, archive, BackupTarZst, nil, record))
+	check(record)
+	inArchive, err := archiveManifest(archive, BackupTarZst)
+	require.NoError(t, err)
+	require.Equal(t, want.Entries, inArchive.Entries)
+
+	record = &manifestRecorder{}
+	linked, copied, err := hardlinkTree(src, filepath.Join(t.TempDir(), "data"), defaultImmutablePatterns, nil, record)
+	require.NoError(t, err)
+	require.Equal(t, 9, linked+copied)
+	check(record)
+}
+
+func TestCopyTreeThrottled(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "data")
+	writeDataDir(t, src, 3, 64*1024)
+
+	// the first chunk goes through at once, the other 128KiB take half a second at 256KiB/s
+	start := time.Now()
+	require.NoError(t, copyTree(src, filepath.Join(t.TempDir(), "data"), copyOptions{workers: 3, rate: 256 * 1024}))
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(450*time.Millisecond))
+}
+
+func TestCopyTreeLogsProgress(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "data")
+	writeDataDir(t, src, 4, 64*1024)
+	var logs bytes.Buffer
+	defer Logger.SetOutput(Logger.Writer())
+	Logger.SetOutput(&logs)
+	defer func(interval time.Duration) { copyProgressInterval = interval }(copyProgressInterval)
+	copyProgressInterval = 50 * time.Millisecond
+
+	require.NoError(t, copyTree(src, filepath.Join(t.TempDir(), "data"), copyOptions{workers: 1, rate: 1024 * 1024}))
+	require.Contains(t, logs.String(), "copying "+src+": ")
+	require.Contains(t, logs.String(), "copied "+src+": 4/4 files, 256.0KiB/256.0KiB")
+	require.Contains(t, logs.String(), "eta")
+}
+
+func BenchmarkCopyTree(b *testing.B) {
+	src := filepath.Join(b.TempDir(), "data")
+	// a synthetic data dir of many small table files
+	writeDataDir(b, src, 2000, 32*1024)
+	workers := []int{1, 4}
+	if n := runtime.NumCPU(); n > 4 {
+		workers = append(workers, n)
+	}
+	for _, n := range workers {
+		b.Run(fmt.Sprintf("workers=%d", n), func(b *testing.B) {
+			b.SetBytes(2000 * 32 * 1024)
+			for i := 0; i < b.N; i++ {
+				dst := filepath.Join(b.TempDir(), "data")
+				if err := copyTree(src, dst, copyOptions{workers: n}); err != nil {
+					b.Fatal(err)
+				}
+				b.StopTimer()
+				os.RemoveAll(dst)
+				b.StartTimer()
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package cosmovisor
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Detector finds an upgrade in the output of the daemon.
+// It is given every line of a single output stream in order, so it can keep state between lines.
+type Detector interface {
+	// Detect returns the upgrade once a line confirms it, and nil for every other line
+	Detect(line string) (*UpgradeInfo, error)
+}
+
+// The detectors that can be selected in the config
+const (
+	DetectorPlain  = "plain"
+	DetectorJSON   = "json"
+	DetectorLogfmt = "logfmt"
+	DetectorRegex  = "regex"
+)
+
+// NewDetector returns a fresh Detector running every detector selected in cfg.
+// Each output stream needs its own, as detectors keep state between lines.
+func NewDetector(cfg *Config) (Detector, error) {
+	detectors := make([]Detector, 0, len(cfg.UpgradeDetectors))
+	for _, name := range cfg.UpgradeDetectors {
+		switch name {
+		case DetectorPlain:
+			detectors = append(detectors, NewPlainDetector())
+		case DetectorJSON:
+			detectors = append(detectors, NewJSONDetector())
+		case DetectorLogfmt:
+			detectors = append(detectors, NewLogfmtDetector())
+		case DetectorRegex:
+			if cfg.UpgradeRegex == "" {
+				return nil, fmt.Errorf("the %s detector needs an upgrade regex", DetectorRegex)
+			}
+			re, err := regexp.Compile(cfg.UpgradeRegex)
+			if err != nil {
+				return nil, err
+			}
+			d, err := NewRegexDetector(re)
+			if err != nil {
+				return nil, err
+			}
+			detectors = append(detectors, d)
+		default:
+			return nil, fmt.Errorf("unknown upgrade detector %q, expected %s, %s, %s or %s",
+				name, DetectorPlain, DetectorJSON, DetectorLogfmt, DetectorRegex)
+		}
+	}
+	if len(detectors) == 0 {
+		return DefaultDetector(), nil
+	}
+	return MultiDetector(detectors...), nil
+}
+
+// DefaultDetector returns a Detector for the log formats of the SDK, plain text and json
+func DefaultDetector() Detector {
+	return MultiDetector(NewPlainDetector(), NewJSONDetector())
+}
+
+// multiDetector runs several detectors on the same lines
+type multiDetector []Detector
+
+// MultiDetector returns a Detector that gives every line to each of detectors, and returns the first upgrade any of them finds
+func MultiDetector(detectors ...Detector) Detector {
+	if len(detectors) == 1 {
+		return detectors[0]
+	}
+	return multiDetector(detectors)
+}
+
+func (m multiDetector) Detect(line string) (*UpgradeInfo, error) {
+	for _, d := range m {
+		info, err := d.Detect(line)
+		if err != nil || info != nil {
+			return info, err
+		}
+	}
+	return nil, nil
+}
+
+// messageParser finds the upgrade message in a line of a particular log format,
+// returning the message and whether it is a plain log line with fields after it, or ok false if there is none
+type messageParser func(line string) (msg string, plain bool, ok bool, err error)
+
+type sdkDetectorState int
+
+const (
+	sdkDetectorInitial sdkDetectorState = iota
+	// sdkDetectorCollecting is when the info is json spread over several lines
+	sdkDetectorCollecting
+	sdkDetectorPending
+)
+
+// sdkDetector finds the upgrade message the SDK logs, and waits for the panic or consensus failure that confirms it.
+// It is shared by the log formats, which only differ in how the message is found in a line.
+type sdkDetector struct {
+	parse messageParser
+	state sdkDetectorState
+	info  *UpgradeInfo
+	// partial is the json info collected so far, and partialLines how many lines it spans
+	partial      string
+	partialLines int
+}
+
+// NewPlainDetector returns a Detector for the plain text logs of the SDK, the console output of tendermint and zerolog
+func NewPlainDetector() Detector {
+	return &sdkDetector{parse: func(line string) (string, bool, bool, error) {
+		return line, true, true, nil
+	}}
+}
+
+// NewJSONDetector returns a Detector for the json logs of the SDK, as written by zerolog
+func NewJSONDetector() Detector {
+	return &sdkDetector{parse: func(line string) (string, bool, bool, error) {
+		jsonLog, ok := isJSONLog(line)
+		if !ok {
+			return "", false, false, nil
+		}
+		jsonLine, err := parseJSONLog(jsonLog)
+		if err != nil {
+			return "", false, false, err
+		}
+		return jsonLine.Message, false, true, nil
+	}}
+}
+
+// NewLogfmtDetector returns a Detector for logfmt logs, where the message is in the msg or message field
+func NewLogfmtDetector() Detector {
+	return &sdkDetector{parse: func(line string) (string, bool, bool, error) {
+		fields := parseLogfmt(line)
+		msg, ok := fields["msg"]
+		if !ok {
+			msg, ok = fields["message"]
+		}
+		return msg, false, ok, nil
+	}}
+}
+
+func (d *sdkDetector) Detect(line string) (*UpgradeInfo, error) {
+	switch d.state {
+	case sdkDetectorInitial:
+		// Don't use the regexp unless we are actually looking at an upgrade line.
+		// Compiled regex matching is about 20x more expensive than strings.Contains(). (10 vs 200).
+		if !(strings.Contains(line, upgradeText) && strings.Contains(line, neededText)) {
+			return nil, nil
+		}
+		msg, plain, ok, err := d.parse(line)
+		if err != nil || !ok {
+			return nil, err
+		}
+		// Parse the info, and kick into holding for panic or consensus failure message.
+		info, partial, err := parseUpgradeMessage(msg, plain)
+		if err != nil || info == nil {
+			return nil, err
+		}
+		d.info = info
+		d.state = sdkDetectorPending
+		if partial != "" {
+			d.partial, d.partialLines = partial, 1
+			d.state = sdkDetectorCollecting
+		}
+	case sdkDetectorCollecting:
+		// The info is pretty printed json, keep adding lines until it is complete.
+		// If it never is, we are left with what the first line had.
+		if isUpgradeConfirmation(line) {
+			return d.info, nil
+		}
+		d.partial += "\n" + line
+		d.partialLines++
+		value, incomplete := jsonPrefix(d.partial)
+		switch {
+		case value != "":
+			d.info.Info = value
+		case incomplete && d.partialLines < maxInfoLines:
+			return nil, nil
+		}
+		d.state = sdkDetectorPending
+	case sdkDetectorPending:
+		// We have hit the panic or consensus failure after an upgrade log message, return out and update.
+		if isUpgradeConfirmation(line) {
+			return d.info, nil
+		}
+	}
+	return nil, nil
+}
+
+// isUpgradeConfirmation returns true for the panic or consensus failure the SDK logs after the upgrade message
+func isUpgradeConfirmation(line string) bool {
+	return strings.Contains(line, panicText) || strings.Contains(line, consensusFailText)
+}
+
+// regexDetector finds an upgrade with a user supplied regexp
+type regexDetector struct {
+	re *regexp.Regexp
+	// the submatch index of each named group, -1 if the regexp doesn't have it
+	name, height, time, info int
+}
+
+// NewRegexDetector returns a Detector that takes the first line matching re as the upgrade, without waiting for a confirmation.
+// The regexp must have a named group "name", and can have the groups "height", "time" (RFC3339) and "info".
+func NewRegexDetector(re *regexp.Regexp) (Detector, error) {
+	d := &regexDetector{
+		re:     re,
+		name:   re.SubexpIndex("name"),
+		height: re.SubexpIndex("height"),
+		time:   re.SubexpIndex("time"),
+		info:   re.SubexpIndex("info"),
+	}
+	if d.name < 0 {
+		return nil, fmt.Errorf("upgrade regex %q has no (?P<name>...) group", re)
+	}
+	return d, nil
+}
+
+func (d *regexDetector) Detect(line string) (*UpgradeInfo, error) {
+	subs := d.re.FindStringSubmatch(line)
+	if subs == nil {
+		return nil, nil
+	}
+	info := &UpgradeInfo{Name: subs[d.name]}
+	if d.info >= 0 {
+		info.Info = strings.TrimSpace(subs[d.info])
+	}
+	if d.height >= 0 && subs[d.height] != "" {
+		height, err := strconv.ParseInt(subs[d.height], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing upgrade height: %w", err)
+		}
+		info.Height = height
+	}
+	if d.time >= 0 && subs[d.time] != "" {
+		t, err := time.Parse(time.RFC3339Nano, subs[d.time])
+		if err != nil {
+			return nil, fmt.Errorf("parsing upgrade time: %w", err)
+		}
+		info.Time = t
+	}
+	return info, nil
+}
+
+// parseLogfmt returns the key=value fields of a logfmt line, with quoted values unquoted.
+// Words that are not fields are skipped.
+func parseLogfmt(line string) map[string]string {
+	fields := make(map[string]string)
+	for i := 0; i < len(line); {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+		start := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' && line[i] != '\t' {
+			i++
+		}
+		if i >= len(line) || line[i] != '=' {
+			continue
+		}
+		key := line[start:i]
+		i++
+
+		if i < len(line) && line[i] == '"' {
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				end = len(line) - 1
+			}
+			value, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				value = strings.Trim(line[i:end+1], `"`)
+			}
+			fields[key] = value
+			i = end + 1
+			continue
+		}
+		start = i
+		for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+			i++
+		}
+		fields[key] = line[start:i]
+	}
+	return fields
+}
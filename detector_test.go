@@ -0,0 +1,153 @@
+package cosmovisor_test
+
+import (
+	"bufio"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/provenance-io/cosmovisor"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectors(t *testing.T) {
+	customRegex := regexp.MustCompile(`halting for upgrade name=(?P<name>\S+) height=(?P<height>\d+)(?: info=(?P<info>.*))?$`)
+	timeRegex := regexp.MustCompile(`HALT (?P<name>\S+) at (?P<time>\S+)`)
+
+	cases := map[string]struct {
+		detector      func() cosmovisor.Detector
+		lines         []string
+		expectUpgrade *cosmovisor.UpgradeInfo
+	}{
+		"logfmt": {
+			detector: cosmovisor.NewLogfmtDetector,
+			lines: []string{
+				`level=info ts=2022-01-01T00:00:00Z msg="executed block" height=99`,
+				`level=error ts=2022-01-01T00:00:01Z msg="UPGRADE \"v2\" NEEDED at height: 100: {\"binaries\": {\"linux/amd64\": \"https://example.com/v2.zip\"}}" module=x/upgrade`,
+				`level=error ts=2022-01-01T00:00:01Z msg="CONSENSUS FAILURE!!!" err="UPGRADE \"v2\" NEEDED at height: 100" module=consensus`,
+			},
+			expectUpgrade: &cosmovisor.UpgradeInfo{
+				Name:   "v2",
+				Height: 100,
+				Info:   `{"binaries": {"linux/amd64": "https://example.com/v2.zip"}}`,
+			},
+		},
+		"logfmt message key and text info": {
+			detector: cosmovisor.NewLogfmtDetector,
+			lines: []string{
+				`level=error message="UPGRADE \"v2\" NEEDED at height: 100: see the notes" module=x/upgrade`,
+				`panic: UPGRADE "v2" NEEDED at height: 100: see the notes`,
+			},
+			expectUpgrade: &cosmovisor.UpgradeInfo{
+				Name:   "v2",
+				Height: 100,
+				Info:   "see the notes",
+			},
+		},
+		"logfmt needs confirmation": {
+			detector: cosmovisor.NewLogfmtDetector,
+			lines: []string{
+				`level=error msg="UPGRADE \"v2\" NEEDED at height: 100: see the notes" module=x/upgrade`,
+				`level=info msg="still going"`,
+			},
+		},
+		"plain ignores json": {
+			detector: cosmovisor.NewPlainDetector,
+			lines: []string{
+				`{"level":"error","message":"UPGRADE \"v2\" NEEDED at height: 100: /app/plan.json"}`,
+				`{"level":"error","message":"CONSENSUS FAILURE!!!"}`,
+			},
+		},
+		"json ignores plain": {
+			detector: cosmovisor.NewJSONDetector,
+			lines: []string{
+				`01:00 ERR UPGRADE "v2" NEEDED at height: 100: /app/plan.json`,
+				`panic: UPGRADE "v2" NEEDED at height: 100: /app/plan.json`,
+			},
+		},
+		"regex with info": {
+			detector: func() cosmovisor.Detector {
+				d, err := cosmovisor.NewRegexDetector(customRegex)
+				require.NoError(t, err)
+				return d
+			},
+			lines: []string{
+				`[node] halting for upgrade name=v7 height=1200 info={"binaries": {"any": "https://example.com/v7.zip"}}`,
+			},
+			expectUpgrade: &cosmovisor.UpgradeInfo{
+				Name:   "v7",
+				Height: 1200,
+				Info:   `{"binaries": {"any": "https://example.com/v7.zip"}}`,
+			},
+		},
+		"regex without info": {
+			detector: func() cosmovisor.Detector {
+				d, err := cosmovisor.NewRegexDetector(customRegex)
+				require.NoError(t, err)
+				return d
+			},
+			lines: []string{
+				`[node] starting`,
+				`[node] halting for upgrade name=v7 height=1200`,
+			},
+			expectUpgrade: &cosmovisor.UpgradeInfo{Name: "v7", Height: 1200},
+		},
+		"regex with time": {
+			detector: func() cosmovisor.Detector {
+				d, err := cosmovisor.NewRegexDetector(timeRegex)
+				require.NoError(t, err)
+				return d
+			},
+			lines: []string{
+				`HALT v8 at 2022-01-01T00:00:00Z`,
+			},
+			expectUpgrade: &cosmovisor.UpgradeInfo{Name: "v8", Time: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		"multi detector with mixed output": {
+			detector: func() cosmovisor.Detector {
+				d, err := cosmovisor.NewRegexDetector(customRegex)
+				require.NoError(t, err)
+				return cosmovisor.MultiDetector(cosmovisor.NewLogfmtDetector(), cosmovisor.DefaultDetector(), d)
+			},
+			lines: []string{
+				`level=info msg="executed block" height=99`,
+				`{"level":"error","message":"UPGRADE \"v2\" NEEDED at height: 100: /app/plan.json"}`,
+				`{"level":"error","module":"consensus","message":"CONSENSUS FAILURE!!!"}`,
+			},
+			expectUpgrade: &cosmovisor.UpgradeInfo{Name: "v2", Height: 100, Info: "/app/plan.json"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			scan := bufio.NewScanner(strings.NewReader(strings.Join(tc.lines, "\n") + "\n"))
+			info, err := cosmovisor.DetectUpgrade(scan, tc.detector())
+			require.NoError(t, err)
+			require.Equal(t, tc.expectUpgrade, info)
+		})
+	}
+}
+
+func TestNewRegexDetectorNeedsName(t *testing.T) {
+	_, err := cosmovisor.NewRegexDetector(regexp.MustCompile(`halting at (?P<height>\d+)`))
+	require.Error(t, err)
+}
+
+func TestNewDetector(t *testing.T) {
+	d, err := cosmovisor.NewDetector(&cosmovisor.Config{
+		UpgradeDetectors: []string{cosmovisor.DetectorLogfmt, cosmovisor.DetectorRegex},
+		UpgradeRegex:     `halt (?P<name>\S+)`,
+	})
+	require.NoError(t, err)
+	info, err := d.Detect("halt v9")
+	require.NoError(t, err)
+	require.Equal(t, &cosmovisor.UpgradeInfo{Name: "v9"}, info)
+
+	_, err = cosmovisor.NewDetector(&cosmovisor.Config{UpgradeDetectors: []string{"xml"}})
+	require.Error(t, err)
+
+	_, err = cosmovisor.NewDetector(&cosmovisor.Config{UpgradeDetectors: []string{cosmovisor.DetectorRegex}})
+	require.Error(t, err)
+}
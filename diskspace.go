@@ -0,0 +1,93 @@
+package cosmovisor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrInsufficientSpace is returned when a backup or download would not fit on its filesystem
+var ErrInsufficientSpace = errors.New("not enough disk space")
+
+// DiskSpacePolicy controls what happens when the data backup would not fit on its filesystem
+type DiskSpacePolicy string
+
+const (
+	// DiskSpaceFail fails the upgrade before anything is written (the default)
+	DiskSpaceFail DiskSpacePolicy = "fail"
+	// DiskSpaceSkipBackup goes on with the upgrade without a data backup
+	DiskSpaceSkipBackup DiskSpacePolicy = "skip-backup"
+)
+
+// freeSpace returns the bytes available to us on the filesystem holding path, it is a variable for tests
+var freeSpace = statfsFree
+
+// sameFilesystem reports whether two existing paths are on the same filesystem, it is a variable for tests
+var sameFilesystem = statSameDevice
+
+// existingParent returns path, or the closest of its parents that exists
+func existingParent(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil || filepath.Dir(path) == path {
+			return path
+		}
+		path = filepath.Dir(path)
+	}
+}
+
+// checkDiskSpace returns an error wrapping ErrInsufficientSpace if need bytes won't fit on the filesystem of path,
+// which doesn't have to exist yet. Where the free space can't be told, it logs that and lets it pass.
+func checkDiskSpace(path string, need int64, what string) error {
+	dir := existingParent(path)
+	free, err := freeSpace(dir)
+	if err != nil {
+		Logger.Printf("cannot check the free disk space for %s: %v", what, err)
+		return nil
+	}
+	if free < need {
+		return fmt.Errorf("%w for %s in %s: it needs about %s, only %s is free", ErrInsufficientSpace, what, dir, HumanSize(need), HumanSize(free))
+	}
+	return nil
+}
+
+// backupSpace estimates the bytes the data backup of cfg into backupDir will take.
+// Archives are assumed not to compress, as most of the data is compressed already,
+// and hardlinked files are assumed to take no space, as long as backupDir is on the filesystem of the data dir.
+// Otherwise they can't be linked, and are counted in full like the files that are copied.
+func backupSpace(cfg *Config, backupDir string) (int64, error) {
+	patterns := cfg.ImmutablePatterns
+	if len(patterns) == 0 {
+		patterns = defaultImmutablePatterns
+	}
+	linking := false
+	if cfg.BackupFormat == BackupHardlink {
+		same, err := sameFilesystem(cfg.DataDir, existingParent(backupDir))
+		if err != nil {
+			Logger.Printf("cannot tell if %s can be hardlinked into %s, counting it as copied: %v", cfg.DataDir, backupDir, err)
+		}
+		linking = same
+	}
+	var size int64
+	err := walkData(cfg.DataDir, cfg.backupFilter(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || (linking && isImmutable(path, patterns)) {
+			return nil
+		}
+		size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, rel := range cfg.BackupExtraPaths {
+		extra, err := dirSize(filepath.Join(cfg.Home, rel))
+		if err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+		size += extra
+	}
+	return size, nil
+}
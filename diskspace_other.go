@@ -0,0 +1,28 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package cosmovisor
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// statfsFree is not supported here, so the free disk space is never checked
+func statfsFree(path string) (int64, error) {
+	return 0, fmt.Errorf("not supported on %s", runtime.GOOS)
+}
+
+// statSameDevice is not supported here, so hardlinking is never assumed to work
+func statSameDevice(a, b string) (bool, error) {
+	return false, fmt.Errorf("not supported on %s", runtime.GOOS)
+}
+
+// fileID tells files apart, hardlinks of the same file have the same one
+type fileID struct{}
+
+// fileIDOf is not supported here, so hardlinked files are counted as often as they are found
+func fileIDOf(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
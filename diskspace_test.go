@@ -0,0 +1,94 @@
+package cosmovisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// withFreeSpace makes the disk look like it has free bytes left for the rest of the test
+func withFreeSpace(t *testing.T, free int64) {
+	orig := freeSpace
+	freeSpace = func(string) (int64, error) { return free, nil }
+	t.Cleanup(func() { freeSpace = orig })
+}
+
+func TestStatfsFree(t *testing.T) {
+	free, err := statfsFree(t.TempDir())
+	if err != nil {
+		t.Skipf("free space is not supported: %v", err)
+	}
+	require.Greater(t, free, int64(0))
+}
+
+func TestBackupDataChecksDiskSpace(t *testing.T) {
+	home := t.TempDir()
+	data := filepath.Join(home, "data")
+	require.NoError(t, os.MkdirAll(filepath.Join(data, "state.db"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(data, "state.db", "000001.ldb"), make([]byte, 1000), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(data, "state.db", "MANIFEST-000002"), make([]byte, 100), 0644))
+	info := &UpgradeInfo{Name: "chain2"}
+
+	// the copy needs room for all of it, a hardlink backup only for what isn't linked
+	withFreeSpace(t, 500)
+	cfg := &Config{Home: home, Name: "dummyd", DataDir: data}
+	err := BackupData(cfg, info)
+	require.True(t, errors.Is(err, ErrInsufficientSpace), err)
+	require.Contains(t, err.Error(), "only 500B is free")
+	require.NoFileExists(t, filepath.Join(cfg.BackupDir(info.Name), ".keep"))
+	require.NoDirExists(t, filepath.Join(cfg.BackupDir(info.Name), "data"))
+
+	cfg.DiskSpacePolicy = DiskSpaceSkipBackup
+	require.NoError(t, BackupData(cfg, info))
+	require.NoFileExists(t, filepath.Join(cfg.BackupDir(info.Name), ".keep"))
+
+	cfg = &Config{Home: home, Name: "dummyd", DataDir: data, BackupFormat: BackupHardlink}
+	require.NoError(t, BackupData(cfg, info))
+	require.FileExists(t, filepath.Join(cfg.BackupDir(info.Name), ".keep"))
+
+	// but on another filesystem, nothing can be linked
+	require.NoError(t, os.RemoveAll(cfg.BackupDir(info.Name)))
+	orig := sameFilesystem
+	sameFilesystem = func(a, b string) (bool, error) { return false, nil }
+	t.Cleanup(func() { sameFilesystem = orig })
+	err = BackupData(cfg, info)
+	require.True(t, errors.Is(err, ErrInsufficientSpace), err)
+	require.Contains(t, err.Error(), "it needs about 1.1KiB")
+}
+
+func TestStatSameDevice(t *testing.T) {
+	dir := t.TempDir()
+	same, err := statSameDevice(dir, filepath.Dir(dir))
+	if err != nil {
+		t.Skipf("telling devices apart is not supported: %v", err)
+	}
+	require.True(t, same)
+}
+
+func TestFetchChecksDiskSpace(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Length", fmt.Sprint(1000))
+		_, _ = w.Write([]byte(strings.Repeat("x", 1000)))
+	}))
+	defer server.Close()
+
+	withFreeSpace(t, 1500)
+	_, _, err := fetchResumable(context.Background(), server.URL+"/autod", t.TempDir())
+	require.True(t, errors.Is(err, ErrInsufficientSpace), err)
+	require.Equal(t, 1, requests, "running out of space is not retried")
+
+	withFreeSpace(t, 2000)
+	_, file, err := fetchResumable(context.Background(), server.URL+"/autod", t.TempDir())
+	require.NoError(t, err)
+	require.FileExists(t, file)
+}
@@ -0,0 +1,44 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package cosmovisor
+
+import (
+	"os"
+	"syscall"
+)
+
+// statfsFree returns the bytes available to unprivileged users on the filesystem holding path
+func statfsFree(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// statSameDevice reports whether a and b are on the same device, which a hardlink between them needs
+func statSameDevice(a, b string) (bool, error) {
+	var sa, sb syscall.Stat_t
+	if err := syscall.Stat(a, &sa); err != nil {
+		return false, err
+	}
+	if err := syscall.Stat(b, &sb); err != nil {
+		return false, err
+	}
+	return sa.Dev == sb.Dev, nil
+}
+
+// fileID tells files apart, hardlinks of the same file have the same one
+type fileID struct {
+	dev, ino uint64
+}
+
+// fileIDOf returns the device and inode of the file info describes
+func fileIDOf(info os.FileInfo) (fileID, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
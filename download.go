@@ -0,0 +1,439 @@
+package cosmovisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	getter "github.com/hashicorp/go-getter"
+)
+
+const (
+	stagingDir = "staging"
+	// unpackPrefix starts the name of the dirs downloads are unpacked in, before they are moved into place
+	unpackPrefix = "unpack-"
+	// partSuffix marks a download that isn't complete yet, it is resumed on the next attempt
+	partSuffix = ".part"
+	// validatorSuffix marks the file holding the ETag or Last-Modified of a partial download
+	validatorSuffix = ".validator"
+	// downloadAttempts is how many times an interrupted transfer is resumed before giving up
+	downloadAttempts = 5
+)
+
+// StagingDir is where the named upgrade is downloaded and unpacked before it is moved into the upgrades dir
+func (cfg *Config) StagingDir(upgradeName string) string {
+	return filepath.Join(cfg.Root(), stagingDir, url.PathEscape(upgradeName))
+}
+
+// DownloadBinary will grab the binary and place it in the proper directory.
+// Everything is fetched and unpacked in the staging dir, and only moved into the upgrade dir once the binary checks out,
+// so a failed download never leaves a partial upgrade dir behind.
+// Interrupted http downloads are resumed, on the next attempt too, if the server supports range requests.
+// With RequireChecksum, a binary without a sha256 or sha512 checksum in its url is refused,
+// only the entry for this os/arch (or any) is checked.
+// With TrustedKeys, a binary without a detached signature by one of the trusted keys is refused.
+func DownloadBinary(cfg *Config, info *UpgradeInfo) error {
+	return downloadBinary(context.Background(), cfg, info)
+}
+
+// downloadBinary is DownloadBinary, giving up once ctx is done
+func downloadBinary(ctx context.Context, cfg *Config, info *UpgradeInfo) error {
+	entry, src, err := getDownloadEntry(ctx, info)
+	if err != nil {
+		return err
+	}
+	if cfg.RequireChecksum {
+		if err := checkChecksum(entry, src); err != nil {
+			return err
+		}
+	}
+
+	staging := cfg.StagingDir(info.Name)
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		return fmt.Errorf("creating staging dir: %w", err)
+	}
+	original := src
+	src, fetched, err := fetchResumable(ctx, src, staging)
+	if err != nil {
+		return err
+	}
+	if cfg.TrustedKeys != "" {
+		keyring, err := LoadKeyring(cfg.TrustedKeys)
+		if err != nil {
+			return fmt.Errorf("loading trusted keys: %w", err)
+		}
+		// the signature is over the artifact as published, so get hold of it before unpacking
+		if fetched == "" {
+			if src, fetched, err = fetchArtifact(ctx, original, staging); err != nil {
+				return err
+			}
+		}
+		if err := verifySignature(ctx, keyring, original, fetched, staging); err != nil {
+			return err
+		}
+	}
+
+	unpacked, err := os.MkdirTemp(staging, unpackPrefix)
+	if err != nil {
+		return fmt.Errorf("creating staging dir: %w", err)
+	}
+	defer os.RemoveAll(unpacked)
+
+	// download into the bin dir (works for one file)
+	binPath := filepath.Join(unpacked, "bin", cfg.Name)
+	err = getter.GetFile(binPath, src, getter.WithContext(ctx), getter.WithGetters(copyingGetters()))
+
+	// if this fails, let's see if it is a zipped directory
+	if err != nil {
+		if err = os.RemoveAll(filepath.Join(unpacked, "bin")); err != nil {
+			return err
+		}
+		err = getter.Get(unpacked, src, getter.WithContext(ctx), getter.WithGetters(copyingGetters()))
+	}
+	if err != nil {
+		if fetched != "" {
+			// it was complete but doesn't check out, so fetch it again next time
+			_ = os.Remove(fetched)
+		}
+		return err
+	}
+	// if it is successful, let's ensure the binary is executable
+	if err := MarkExecutable(binPath); err != nil {
+		return err
+	}
+	if err := EnsureBinary(binPath); err != nil {
+		return fmt.Errorf("downloaded binary doesn't check out: %w", err)
+	}
+
+	// MkdirTemp makes a private dir, give it the usual permissions before it becomes the upgrade dir
+	if err := os.Chmod(unpacked, 0755); err != nil {
+		return err
+	}
+	dest := cfg.UpgradeDir(info.Name)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(unpacked, dest); err != nil {
+		return fmt.Errorf("moving download into place: %w", err)
+	}
+	// the download is not needed anymore
+	return os.RemoveAll(staging)
+}
+
+// copyingGetters are getter's default getters, except that local files are copied rather than symlinked,
+// as they are moved out of the staging dir afterwards
+func copyingGetters() map[string]getter.Getter {
+	getters := make(map[string]getter.Getter, len(getter.Getters))
+	for scheme, g := range getter.Getters {
+		getters[scheme] = g
+	}
+	getters["file"] = &getter.FileGetter{Copy: true}
+	return getters
+}
+
+// fetchResumable downloads an http(s) src into dir, resuming what an earlier attempt left there.
+// It returns a source for getter pointing at the downloaded file, keeping the getter parameters
+// (checksum, archive) of src so verifying and unpacking work the same as for a direct download,
+// and the path of the downloaded file.
+// Other sources are returned as they are, for getter to fetch. The download stops once ctx is done.
+func fetchResumable(ctx context.Context, src, dir string) (string, string, error) {
+	u, err := url.Parse(src)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return src, "", nil
+	}
+
+	// the getter parameters are not for the server
+	plain, params := splitGetterParams(src)
+	if u, err = url.Parse(plain); err != nil {
+		return "", "", err
+	}
+	file := filepath.Join(dir, downloadName(u.Path))
+
+	// a download completed by an earlier attempt is only used if it is known to be what src serves now
+	if _, err := os.Stat(file); err == nil && !stagedCurrent(ctx, u.String(), file, params) {
+		Logger.Printf("downloading %s again, the staged copy may be out of date", u.Redacted())
+		if err := os.Remove(file); err != nil {
+			return "", "", err
+		}
+	}
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		for attempt := 1; ; attempt++ {
+			err = fetchPart(ctx, u.String(), file+partSuffix)
+			if err == nil {
+				break
+			}
+			var transfer *transferError
+			if !errors.As(err, &transfer) || attempt >= downloadAttempts || ctx.Err() != nil {
+				return "", "", fmt.Errorf("downloading %s: %w", u.Redacted(), err)
+			}
+			Logger.Printf("download of %s interrupted, resuming: %v", u.Redacted(), err)
+		}
+		if err := os.Rename(file+partSuffix, file); err != nil {
+			return "", "", err
+		}
+		// kept to check the download against the server, should it be used again
+		_ = os.Rename(file+partSuffix+validatorSuffix, file+validatorSuffix)
+	}
+
+	return localSource(file, params), file, nil
+}
+
+// stagedCurrent reports whether a complete download of src in file can be used again.
+// With a checksum, getter verifies it anyway. Otherwise the server is asked for a byte of src,
+// if the ETag or Last-Modified kept for file still matches, it answers with that byte rather than all of src.
+func stagedCurrent(ctx context.Context, src, file string, params url.Values) bool {
+	if params.Get("checksum") != "" {
+		return true
+	}
+	stat, err := os.Stat(file)
+	if err != nil {
+		return false
+	}
+	validator, err := os.ReadFile(file + validatorSuffix)
+	if err != nil || len(validator) == 0 {
+		return false
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	req.Header.Set("If-Range", string(validator))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusPartialContent &&
+		strings.HasSuffix(resp.Header.Get("Content-Range"), "/"+strconv.FormatInt(stat.Size(), 10))
+}
+
+// fetchArtifact downloads src into dir as it is, without unpacking it, for any source getter supports.
+// It returns the same as fetchResumable.
+func fetchArtifact(ctx context.Context, src, dir string) (string, string, error) {
+	plain, params := splitGetterParams(src)
+	file := filepath.Join(dir, downloadName(plain))
+	_ = os.Remove(file)
+
+	fetch := url.Values{"archive": {"false"}}
+	if checksum := params.Get("checksum"); checksum != "" {
+		fetch.Set("checksum", checksum)
+	}
+	if err := getter.GetFile(file, withQuery(plain, fetch.Encode()), getter.WithContext(ctx), getter.WithGetters(copyingGetters())); err != nil {
+		return "", "", fmt.Errorf("downloading %s: %w", plain, err)
+	}
+	return localSource(file, params), file, nil
+}
+
+// splitGetterParams splits the getter parameters (checksum, archive) off src, leaving any other query parameters
+func splitGetterParams(src string) (string, url.Values) {
+	params := url.Values{}
+	i := strings.Index(src, "?")
+	if i < 0 {
+		return src, params
+	}
+	query, err := url.ParseQuery(src[i+1:])
+	if err != nil {
+		return src, params
+	}
+	for _, key := range []string{"checksum", "archive"} {
+		if v, ok := query[key]; ok {
+			params[key] = v
+			query.Del(key)
+		}
+	}
+	if len(query) == 0 {
+		return src[:i], params
+	}
+	return src[:i] + "?" + query.Encode(), params
+}
+
+// withQuery adds the encoded query to src, which may have a query already
+func withQuery(src, query string) string {
+	if strings.Contains(src, "?") {
+		return src + "&" + query
+	}
+	return src + "?" + query
+}
+
+// downloadName is the file name a download is kept under, the last element of its path,
+// which matters as getter picks the decompressor by extension
+func downloadName(p string) string {
+	if i := strings.Index(p, "?"); i >= 0 {
+		p = p[:i]
+	}
+	name := path.Base(filepath.ToSlash(p))
+	if name == "." || name == "/" || name == "" {
+		return "download"
+	}
+	return name
+}
+
+// localSource is a getter source for a downloaded file, with the getter parameters of the original source
+func localSource(file string, params url.Values) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(file), RawQuery: params.Encode()}).String()
+}
+
+// verifySignature fetches the detached signature of src, trying each of signatureSuffixes,
+// and checks it is a signature of the downloaded file by a key in the keyring
+func verifySignature(ctx context.Context, keyring *Keyring, src, file, dir string) error {
+	plain, _ := splitGetterParams(src)
+	for _, suffix := range signatureSuffixes {
+		sigSrc := plain + suffix
+		if u, err := url.Parse(plain); err == nil && u.Scheme != "" && u.Opaque == "" {
+			u.Path += suffix
+			u.RawPath = ""
+			sigSrc = u.String()
+		}
+
+		sigFile := filepath.Join(dir, downloadName(file)+suffix)
+		_ = os.Remove(sigFile)
+		if err := getter.GetFile(sigFile, withQuery(sigSrc, "archive=false"), getter.WithContext(ctx), getter.WithGetters(copyingGetters())); err != nil {
+			continue
+		}
+		sig, err := os.ReadFile(sigFile)
+		if err != nil {
+			return err
+		}
+		signer, err := keyring.Verify(file, sig)
+		if err != nil {
+			return fmt.Errorf("verifying signature %s: %w", sigSrc, err)
+		}
+		Logger.Printf("verified signature of %s by %s", plain, signer)
+		return nil
+	}
+	return fmt.Errorf("%w: found no %s next to %s", ErrUnsigned, strings.Join(signatureSuffixes, " or "), plain)
+}
+
+// transferError is a download that broke off after it started, which is worth resuming
+type transferError struct {
+	err error
+}
+
+func (e *transferError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transferError) Unwrap() error {
+	return e.err
+}
+
+// fetchPart downloads src into part, continuing from the end of part if the server supports range requests.
+// The ETag or Last-Modified of the download is kept next to part, so a changed file is downloaded from the start.
+func fetchPart(ctx context.Context, src, part string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return err
+	}
+
+	var offset int64
+	if stat, err := os.Stat(part); err == nil && stat.Size() > 0 {
+		if validator, err := os.ReadFile(part + validatorSuffix); err == nil && len(validator) > 0 {
+			offset = stat.Size()
+			req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+			req.Header.Set("If-Range", string(validator))
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return &transferError{err}
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), "bytes "+strconv.FormatInt(offset, 10)+"-") {
+			return fmt.Errorf("server resumed at the wrong offset: %s", resp.Header.Get("Content-Range"))
+		}
+		flags |= os.O_APPEND
+	case http.StatusOK:
+		// a fresh download, because the server doesn't do ranges or the file changed
+		flags |= os.O_TRUNC
+		offset = 0
+	case http.StatusRequestedRangeNotSatisfiable:
+		// we already have all of it, the checksum will tell if that is true
+		return nil
+	default:
+		return fmt.Errorf("bad response code: %d", resp.StatusCode)
+	}
+
+	// the download is unpacked next to itself, so it needs room for about twice its size
+	if resp.ContentLength > 0 {
+		if err := checkDiskSpace(part, resp.ContentLength+offset+resp.ContentLength, "the download"); err != nil {
+			return err
+		}
+	}
+
+	validator := resp.Header.Get("ETag")
+	if validator == "" {
+		validator = resp.Header.Get("Last-Modified")
+	}
+	if err := os.WriteFile(part+validatorSuffix, []byte(validator), 0600); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(part, flags, 0644)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return &transferError{fmt.Errorf("after %d bytes: %w", offset+n, err)}
+	}
+	if resp.ContentLength >= 0 && n < resp.ContentLength {
+		return &transferError{fmt.Errorf("after %d bytes: %w", offset+n, io.ErrUnexpectedEOF)}
+	}
+	return nil
+}
+
+// CleanStaging removes what failed downloads left in the staging dir.
+// Partial downloads are kept so they can be resumed, unless their upgrade has been installed since.
+func CleanStaging(cfg *Config) error {
+	root := filepath.Join(cfg.Root(), stagingDir)
+	entries, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading staging dir: %w", err)
+	}
+
+	for _, entry := range entries {
+		dir := filepath.Join(root, entry.Name())
+		name, err := url.PathUnescape(entry.Name())
+		if err == nil && EnsureBinary(cfg.UpgradeBin(name)) == nil {
+			Logger.Printf("removing staged download of installed upgrade %q", name)
+			if err := os.RemoveAll(dir); err != nil {
+				return err
+			}
+			continue
+		}
+
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			if f.IsDir() && strings.HasPrefix(f.Name(), unpackPrefix) {
+				Logger.Printf("removing leftover staging dir %s", filepath.Join(dir, f.Name()))
+				if err := os.RemoveAll(filepath.Join(dir, f.Name())); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
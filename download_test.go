@@ -0,0 +1,246 @@
+package cosmovisor_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/provenance-io/cosmovisor"
+)
+
+// flakyServer serves a file over http, breaking off the transfer of full requests half way while broken is set
+type flakyServer struct {
+	content []byte
+	mutex   sync.Mutex
+	broken  bool
+	// ranges records the Range header of every request
+	ranges []string
+}
+
+func (f *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	broken := f.broken
+	f.ranges = append(f.ranges, r.Header.Get("Range"))
+	f.mutex.Unlock()
+
+	w.Header().Set("ETag", `"v1"`)
+	if broken {
+		if r.Header.Get("Range") != "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(f.content)))
+		_, _ = w.Write(f.content[:len(f.content)/2])
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+		return
+	}
+	http.ServeContent(w, r, "autod.zip", time.Time{}, bytes.NewReader(f.content))
+}
+
+func (f *flakyServer) setBroken(broken bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.broken = broken
+}
+
+func (f *flakyServer) requests() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string(nil), f.ranges...)
+}
+
+func zipUpgradeInfo(name, url string) *cosmovisor.UpgradeInfo {
+	// sha256sum ./testdata/repo/zip_directory/autod.zip
+	return &cosmovisor.UpgradeInfo{
+		Name: name,
+		Info: fmt.Sprintf(`{"binaries":{"%s": "%s/autod.zip?checksum=sha256:3784e4574cad69b67e34d4ea4425eff140063a3870270a301d6bb24a098a27ae"}}`,
+			cosmovisor.OSArch(), url),
+	}
+}
+
+func TestDownloadBinaryResumes(t *testing.T) {
+	content, err := os.ReadFile("testdata/repo/zip_directory/autod.zip")
+	require.NoError(t, err)
+	flaky := &flakyServer{content: content, broken: true}
+	server := httptest.NewServer(flaky)
+	defer server.Close()
+
+	home := copyTestData(t, "download")
+	cfg := &cosmovisor.Config{Home: home, Name: "autod", AllowDownloadBinaries: true}
+	info := zipUpgradeInfo("amazonas", server.URL)
+
+	// the transfer breaks off, and resuming fails too
+	require.Error(t, cosmovisor.DownloadBinary(cfg, info))
+	_, err = os.Stat(cfg.UpgradeDir("amazonas"))
+	require.True(t, os.IsNotExist(err), "no partial upgrade dir must be left behind")
+	part, err := os.Stat(filepath.Join(cfg.StagingDir("amazonas"), "autod.zip.part"))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(content)/2), part.Size())
+
+	// the next attempt picks up where the last one stopped
+	flaky.setBroken(false)
+	require.NoError(t, cosmovisor.DoUpgrade(cfg, info))
+	require.NoError(t, cosmovisor.EnsureBinary(cfg.UpgradeBin("amazonas")))
+	require.Equal(t, []string{"", fmt.Sprintf("bytes=%d-", len(content)/2), fmt.Sprintf("bytes=%d-", len(content)/2)}, flaky.requests())
+
+	_, err = os.Stat(cfg.StagingDir("amazonas"))
+	require.True(t, os.IsNotExist(err), "staging dir must be removed once installed")
+}
+
+func TestDownloadBinaryBadChecksum(t *testing.T) {
+	server := httptest.NewServer(&flakyServer{content: []byte("not the zip we are looking for")})
+	defer server.Close()
+
+	home := copyTestData(t, "download")
+	cfg := &cosmovisor.Config{Home: home, Name: "autod", AllowDownloadBinaries: true}
+
+	require.Error(t, cosmovisor.DownloadBinary(cfg, zipUpgradeInfo("amazonas", server.URL)))
+	_, err := os.Stat(cfg.UpgradeDir("amazonas"))
+	require.True(t, os.IsNotExist(err), "no partial upgrade dir must be left behind")
+	_, err = os.Stat(filepath.Join(cfg.StagingDir("amazonas"), "autod.zip"))
+	require.True(t, os.IsNotExist(err), "a download that doesn't check out must not be reused")
+}
+
+func TestDownloadBinaryRevalidatesStaged(t *testing.T) {
+	content, err := os.ReadFile("testdata/repo/raw_binary/autod")
+	require.NoError(t, err)
+	flaky := &flakyServer{content: content}
+	server := httptest.NewServer(flaky)
+	defer server.Close()
+
+	home := copyTestData(t, "download")
+	cfg := &cosmovisor.Config{Home: home, Name: "autod", AllowDownloadBinaries: true}
+	// without a checksum, only the server can tell if a staged download is still what it serves
+	info := &cosmovisor.UpgradeInfo{Name: "amazonas", Info: fmt.Sprintf(`{"binaries":{"any":"%s/autod"}}`, server.URL)}
+	stage := func(content []byte, validator string) {
+		staged := filepath.Join(cfg.StagingDir("amazonas"), "autod")
+		require.NoError(t, os.MkdirAll(filepath.Dir(staged), 0755))
+		require.NoError(t, os.WriteFile(staged, content, 0644))
+		require.NoError(t, os.WriteFile(staged+".validator", []byte(validator), 0600))
+	}
+
+	// one left from an older release is downloaded again
+	stage([]byte("#!/bin/sh\necho old\n"), `"v0"`)
+	require.NoError(t, cosmovisor.DownloadBinary(cfg, info))
+	bz, err := os.ReadFile(cfg.UpgradeBin("amazonas"))
+	require.NoError(t, err)
+	require.Equal(t, content, bz)
+	require.Equal(t, []string{"bytes=0-0", ""}, flaky.requests())
+
+	// one that still matches is used as it is
+	require.NoError(t, os.RemoveAll(cfg.UpgradeDir("amazonas")))
+	stage(content, `"v1"`)
+	require.NoError(t, cosmovisor.DownloadBinary(cfg, info))
+	require.NoError(t, cosmovisor.EnsureBinary(cfg.UpgradeBin("amazonas")))
+	require.Equal(t, []string{"bytes=0-0", "", "bytes=0-0"}, flaky.requests())
+}
+
+func TestCleanStaging(t *testing.T) {
+	home := copyTestData(t, "validate")
+	cfg := &cosmovisor.Config{Home: home, Name: "dummyd"}
+
+	// chain2 is installed in the testdata, chain9 is not
+	for _, dir := range []string{
+		filepath.Join(cfg.StagingDir("chain2"), "unpack-1"),
+		filepath.Join(cfg.StagingDir("chain9"), "unpack-2"),
+	} {
+		require.NoError(t, os.MkdirAll(dir, 0755))
+	}
+	part := filepath.Join(cfg.StagingDir("chain9"), "chain9.zip.part")
+	require.NoError(t, os.WriteFile(part, []byte("half"), 0644))
+
+	require.NoError(t, cosmovisor.CleanStaging(cfg))
+
+	_, err := os.Stat(cfg.StagingDir("chain2"))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(cfg.StagingDir("chain9"), "unpack-2"))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(part)
+	require.NoError(t, err, "partial downloads are kept to be resumed")
+}
+
+func TestDownloadBinaryRequireChecksum(t *testing.T) {
+	binary, err := filepath.Abs("testdata/repo/raw_binary/autod")
+	require.NoError(t, err)
+	// sha256sum ./testdata/repo/raw_binary/autod
+	sha256 := "89959a5782b7c301a23e5ed45f240549c628addc90eadb24b6f9df4b71da7089"
+
+	cases := map[string]struct {
+		entry  string
+		url    string
+		errMsg string
+	}{
+		"no checksum": {
+			entry:  cosmovisor.OSArch(),
+			url:    binary,
+			errMsg: "binary for " + cosmovisor.OSArch() + " has no checksum",
+		},
+		"no checksum for any": {
+			entry:  "any",
+			url:    binary + "?archive=false",
+			errMsg: "binary for any has no checksum",
+		},
+		"md5 checksum": {
+			entry:  cosmovisor.OSArch(),
+			url:    binary + "?checksum=md5:b1a2f6a5d2bdb7d08e8a9e1f1e3ffc3a",
+			errMsg: "binary for " + cosmovisor.OSArch() + " needs a sha256 or sha512 checksum",
+		},
+		"truncated sha256": {
+			entry:  cosmovisor.OSArch(),
+			url:    binary + "?checksum=sha256:" + sha256[:20],
+			errMsg: "needs a sha256 or sha512 checksum",
+		},
+		"sha256": {
+			entry: cosmovisor.OSArch(),
+			url:   binary + "?checksum=sha256:" + sha256,
+		},
+		"sha256 without type": {
+			entry: "any",
+			url:   binary + "?checksum=" + sha256,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			home := copyTestData(t, "download")
+			cfg := &cosmovisor.Config{Home: home, Name: "autod", AllowDownloadBinaries: true, RequireChecksum: true}
+			info := &cosmovisor.UpgradeInfo{
+				Name: "amazonas",
+				Info: fmt.Sprintf(`{"binaries":{"%s": "%s"}}`, tc.entry, tc.url),
+			}
+
+			err := cosmovisor.DownloadBinary(cfg, info)
+			if tc.errMsg != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.errMsg)
+				_, err = os.Stat(cfg.UpgradeDir("amazonas"))
+				require.True(t, os.IsNotExist(err))
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, cosmovisor.EnsureBinary(cfg.UpgradeBin("amazonas")))
+		})
+	}
+
+	// only the entry for this os/arch is checked
+	home := copyTestData(t, "download")
+	cfg := &cosmovisor.Config{Home: home, Name: "autod", AllowDownloadBinaries: true, RequireChecksum: true}
+	info := &cosmovisor.UpgradeInfo{
+		Name: "amazonas",
+		Info: fmt.Sprintf(`{"binaries":{"%s": "%s?checksum=sha256:%s", "other/arch": "%s"}}`, cosmovisor.OSArch(), binary, sha256, binary),
+	}
+	require.NoError(t, cosmovisor.DownloadBinary(cfg, info))
+}
@@ -0,0 +1,71 @@
+package cosmovisor
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+// Exit codes for cosmovisor's own failures.
+// When the daemon exits by itself, cosmovisor exits with the daemon's exit code instead,
+// or 128+signal if it was killed by a signal, so these are kept above that range.
+const (
+	// ExitCodeError is any cosmovisor failure without a more specific code
+	ExitCodeError = 200
+	// ExitCodeUsage is for invalid commands, flags or arguments
+	ExitCodeUsage = 201
+	// ExitCodeConfig is for a config that can't be loaded or is invalid
+	ExitCodeConfig = 202
+	// ExitCodeBinary is for a current or upgrade binary that is missing or can't be run
+	ExitCodeBinary = 203
+	// ExitCodeDownload is for a failure to download an upgrade binary
+	ExitCodeDownload = 204
+	// ExitCodeBackup is for a failure to back up the data dir before an upgrade
+	ExitCodeBackup = 205
+	// ExitCodeSymlink is for a failure to switch the current link to the upgrade
+	ExitCodeSymlink = 206
+	// ExitCodeCrashLoop is for when the daemon kept crashing and the restart policy gave up
+	ExitCodeCrashLoop = 207
+)
+
+// Error is a cosmovisor failure along with the exit code it maps to
+type Error struct {
+	Err  error
+	Code int
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// WithExitCode wraps err so ExitCode returns code for it. It returns nil if err is nil.
+func WithExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Err: err, Code: code}
+}
+
+// ExitCode returns the code cosmovisor should exit with, given the result of running the daemon.
+// That is 0 for no error, the code of a cosmovisor Error, the daemon's own exit code,
+// 128+signal if the daemon was killed by a signal, or ExitCodeError for anything else.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var cerr *Error
+	if errors.As(err, &cerr) {
+		return cerr.Code
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return exitErr.ExitCode()
+	}
+
+	return ExitCodeError
+}
@@ -0,0 +1,69 @@
+package cosmovisor_test
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/provenance-io/cosmovisor"
+)
+
+func TestExitCode(t *testing.T) {
+	crash := daemonExitError(t, "2")
+	killed := exec.Command("sh", "-c", "kill -9 $$").Run()
+	require.Error(t, killed)
+
+	loopCfg := &cosmovisor.Config{RestartPolicy: cosmovisor.RestartOnFailure, RestartMaxRestarts: 1, RestartWindow: time.Minute}
+	restarter := cosmovisor.NewRestarter(loopCfg)
+	_, _, err := restarter.Next(crash, time.Now())
+	require.NoError(t, err)
+	_, _, crashLoop := restarter.Next(crash, time.Now())
+	require.ErrorIs(t, crashLoop, cosmovisor.ErrCrashLoop)
+
+	cases := map[string]struct {
+		err  error
+		code int
+	}{
+		"success":             {err: nil, code: 0},
+		"daemon exit code":    {err: crash, code: 2},
+		"daemon killed":       {err: killed, code: 128 + 9},
+		"wrapped daemon exit": {err: fmt.Errorf("daemon: %w", crash), code: 2},
+		"crash loop":          {err: crashLoop, code: cosmovisor.ExitCodeCrashLoop},
+		"cosmovisor error":    {err: cosmovisor.WithExitCode(cosmovisor.ExitCodeBackup, errors.New("disk full")), code: cosmovisor.ExitCodeBackup},
+		"wrapped cosmovisor error": {
+			err:  fmt.Errorf("upgrading: %w", cosmovisor.WithExitCode(cosmovisor.ExitCodeDownload, errors.New("404"))),
+			code: cosmovisor.ExitCodeDownload,
+		},
+		"other error": {err: errors.New("something"), code: cosmovisor.ExitCodeError},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.code, cosmovisor.ExitCode(tc.err))
+		})
+	}
+
+	require.Nil(t, cosmovisor.WithExitCode(cosmovisor.ExitCodeConfig, nil))
+}
+
+func (s *upgradeTestSuite) TestDoUpgradeExitCodes() {
+	home := copyTestData(s.T(), "validate")
+
+	// missing binary without downloads
+	cfg := &cosmovisor.Config{Home: home, Name: "dummyd"}
+	err := cosmovisor.DoUpgrade(cfg, &cosmovisor.UpgradeInfo{Name: "missing"})
+	s.Require().Equal(cosmovisor.ExitCodeBinary, cosmovisor.ExitCode(err))
+
+	// nothing to download
+	cfg.AllowDownloadBinaries = true
+	err = cosmovisor.DoUpgrade(cfg, &cosmovisor.UpgradeInfo{Name: "missing"})
+	s.Require().Equal(cosmovisor.ExitCodeDownload, cosmovisor.ExitCode(err))
+
+	// bad config
+	_, err = cosmovisor.LoadConfig(cosmovisor.ConfigOverrides{"home": "relative", "name": "dummyd"})
+	s.Require().Equal(cosmovisor.ExitCodeConfig, cosmovisor.ExitCode(err))
+}
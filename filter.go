@@ -0,0 +1,129 @@
+package cosmovisor
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// dataFilter decides which parts of the data dir a backup takes, from the exclude and include patterns.
+// A nil filter takes everything.
+type dataFilter struct {
+	exclude []string
+	include []string
+}
+
+// backupFilter is the filter of the backup exclude and include patterns of cfg, nil if nothing is excluded
+func (cfg *Config) backupFilter() *dataFilter {
+	if len(cfg.BackupExclude) == 0 {
+		return nil
+	}
+	return &dataFilter{exclude: cfg.BackupExclude, include: cfg.BackupInclude}
+}
+
+// matchDataPattern reports whether pattern matches rel, a slash separated path relative to the data dir.
+// A pattern without a slash matches a name anywhere in the tree, one with a slash matches from the data dir down.
+func matchDataPattern(pattern, rel string) bool {
+	pattern = strings.TrimSuffix(pattern, "/")
+	if !strings.Contains(pattern, "/") {
+		rel = path.Base(rel)
+	}
+	ok, _ := path.Match(strings.TrimPrefix(pattern, "/"), rel)
+	return ok
+}
+
+// matchesTree reports whether one of patterns matches rel or one of the dirs above it
+func matchesTree(patterns []string, rel string) bool {
+	for p := rel; p != "." && p != "/"; p = path.Dir(p) {
+		for _, pattern := range patterns {
+			if matchDataPattern(pattern, p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// takes reports whether rel is backed up: it is if it isn't excluded, or if it is included again
+func (f *dataFilter) takes(rel string) bool {
+	if f == nil {
+		return true
+	}
+	return !matchesTree(f.exclude, rel) || matchesTree(f.include, rel)
+}
+
+// mayIncludeBelow reports whether an include pattern could match something in the excluded dir rel
+func (f *dataFilter) mayIncludeBelow(rel string) bool {
+	dirs := strings.Split(rel, "/")
+	for _, pattern := range f.include {
+		pattern = strings.Trim(pattern, "/")
+		if !strings.Contains(pattern, "/") {
+			return true
+		}
+		parts := strings.Split(pattern, "/")
+		if len(parts) <= len(dirs) {
+			continue
+		}
+		below := true
+		for i, dir := range dirs {
+			if ok, _ := path.Match(parts[i], dir); !ok {
+				below = false
+				break
+			}
+		}
+		if below {
+			return true
+		}
+	}
+	return false
+}
+
+// walkData walks the tree at root like filepath.Walk, leaving out what filter doesn't take.
+// Excluded dirs are only walked into if an include pattern may match below them,
+// and only passed to fn once something below them is, so no empty excluded dir ends up in a backup.
+func walkData(root string, filter *dataFilter, fn filepath.WalkFunc) error {
+	if filter == nil {
+		return filepath.Walk(root, fn)
+	}
+	// pending are the excluded dirs walked into that nothing was taken from yet, outermost first
+	type pendingDir struct {
+		path string
+		info os.FileInfo
+	}
+	var pending []pendingDir
+	// below drops the pending dirs that aren't above p, their walk is over
+	below := func(p string) {
+		for len(pending) > 0 && !strings.HasPrefix(p, pending[len(pending)-1].path+string(filepath.Separator)) {
+			pending = pending[:len(pending)-1]
+		}
+	}
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return fn(p, info, err)
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		below(p)
+		if rel == "." || filter.takes(rel) {
+			for _, dir := range pending {
+				if err := fn(dir.path, dir.info, nil); err != nil {
+					return err
+				}
+			}
+			pending = nil
+			return fn(p, info, nil)
+		}
+		if info.IsDir() && filter.mayIncludeBelow(rel) {
+			pending = append(pending, pendingDir{path: p, info: info})
+			return nil
+		}
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
@@ -0,0 +1,77 @@
+package cosmovisor
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataFilterTakes(t *testing.T) {
+	filter := &dataFilter{
+		exclude: []string{"snapshots", "cs.wal/", "wasm/wasm/cache", "*.tmp"},
+		include: []string{"snapshots/metadata.db"},
+	}
+	cases := map[string]bool{
+		"application.db/000001.ldb":        true,
+		"snapshots":                        false,
+		"snapshots/1/2":                    false,
+		"snapshots/metadata.db":            true,
+		"snapshots/metadata.db/000001.ldb": true,
+		"cs.wal/wal":                       false,
+		"wasm/wasm/cache/modules/abc":      false,
+		"wasm/wasm/state/wasm":             true,
+		"blockstore.db/LOG.tmp":            false,
+		"other/snapshots":                  false,
+		"other/wasm/wasm/cache":            true,
+		"priv_validator_state.json":        true,
+	}
+	for rel, takes := range cases {
+		require.Equal(t, takes, filter.takes(rel), rel)
+	}
+
+	// a leading slash anchors a name at the data dir
+	filter = &dataFilter{exclude: []string{"/snapshots"}}
+	require.False(t, filter.takes("snapshots/1"))
+	require.True(t, filter.takes("other/snapshots/1"))
+
+	var none *dataFilter
+	require.True(t, none.takes("snapshots"))
+}
+
+func TestWalkData(t *testing.T) {
+	dir := t.TempDir()
+	for _, file := range []string{"application.db/1.ldb", "snapshots/1/chunk", "snapshots/metadata.db/1.ldb", "cs.wal/wal"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(file)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, file), []byte("x"), 0644))
+	}
+
+	walked := func(filter *dataFilter) []string {
+		var files []string
+		require.NoError(t, walkData(dir, filter, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(rel))
+			return nil
+		}))
+		sort.Strings(files)
+		return files
+	}
+
+	// excluded dirs are skipped unless something below them may be included
+	require.Equal(t, []string{".", "application.db", "application.db/1.ldb"},
+		walked(&dataFilter{exclude: []string{"snapshots", "cs.wal"}}))
+	require.Equal(t, []string{".", "application.db", "application.db/1.ldb", "snapshots",
+		"snapshots/metadata.db", "snapshots/metadata.db/1.ldb"},
+		walked(&dataFilter{exclude: []string{"snapshots", "cs.wal"}, include: []string{"snapshots/metadata.db"}}))
+	// an excluded dir walked into is left out when nothing below it is included
+	require.Equal(t, []string{".", "application.db", "application.db/1.ldb", "cs.wal", "cs.wal/wal"},
+		walked(&dataFilter{exclude: []string{"snapshots", "cs.wal"}, include: []string{"wal"}}))
+}
@@ -0,0 +1,90 @@
+package cosmovisor
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// defaultImmutablePatterns is used when the config doesn't list immutable file patterns,
+// they match the table files of LevelDB and RocksDB, which are never changed once written
+var defaultImmutablePatterns = []string{"*.sst", "*.ldb"}
+
+// isImmutable reports whether the base name of path matches one of patterns
+func isImmutable(path string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, filepath.Base(path)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hardlinkTree recreates the tree at src, as far as filter takes it, in dst,
+// hardlinking the files matching patterns and copying the rest.
+// Files that can't be linked, e.g. because dst is on another filesystem, are copied too.
+// Linking is only safe while nothing writes to src, as the linked files are shared with it.
+// Anything already at a target in dst is removed rather than written to, as it may be a link to a file in src.
+// With record set, copied files are recorded with the checksum of what was written,
+// and linked files with that of the file they share with src.
+// It returns how many files were linked and copied.
+func hardlinkTree(src, dst string, patterns []string, filter *dataFilter, record *manifestRecorder) (linked, copied int, err error) {
+	var dirs []copyFileJob
+	buf := make([]byte, copyChunk)
+	err = walkData(src, filter, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			// writable until the files are in, the mode is set at the end
+			dirs = append(dirs, copyFileJob{src: path, dst: target, info: info})
+			return os.MkdirAll(target, 0700)
+		case !info.Mode().IsRegular() && info.Mode()&os.ModeSymlink == 0:
+			return nil
+		}
+
+		if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		}
+
+		if isImmutable(path, patterns) {
+			if err := os.Link(path, target); err == nil {
+				linked++
+				if record == nil {
+					return nil
+				}
+				sum, err := hashFile(target, sha256.New())
+				if err != nil {
+					return fmt.Errorf("hashing %s: %w", rel, err)
+				}
+				record.record(rel, info, info.Size(), sum)
+				return nil
+			}
+		}
+		job := copyFileJob{src: path, dst: target, rel: rel, info: info}
+		if err := copyFileThrottled(job, buf, nil, &copyProgress{}, record); err != nil {
+			return fmt.Errorf("copying %s: %w", rel, err)
+		}
+		copied++
+		return nil
+	})
+	if err != nil {
+		return linked, copied, err
+	}
+	return linked, copied, setDirModes(dirs)
+}
@@ -0,0 +1,95 @@
+package cosmovisor
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	historyFile = "history.jsonl"
+
+	// HistoryUpgrade is the action recorded when the current link is switched to an upgrade
+	HistoryUpgrade = "upgrade"
+	// HistoryRollback is the action recorded when the current link is switched back by Rollback
+	HistoryRollback = "rollback"
+)
+
+// HistoryEntry is a single switch of the current link
+type HistoryEntry struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Height int64     `json:"height,omitempty"`
+	// Backup is the upgrade whose data backup a rollback restored
+	Backup string `json:"backup,omitempty"`
+	// MovedData is where a rollback moved the data dir it replaced
+	MovedData string `json:"moved_data,omitempty"`
+}
+
+// HistoryFile is the file the upgrades and rollbacks are recorded in, one json object per line
+func (cfg *Config) HistoryFile() string {
+	return filepath.Join(cfg.Root(), historyFile)
+}
+
+// AppendHistory records entry at the end of the history file, setting its time if it has none
+func AppendHistory(cfg *Config, entry HistoryEntry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(cfg.HistoryFile(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadHistory returns the recorded entries, oldest first.
+// A missing history file is not an error, nothing has been recorded yet.
+func ReadHistory(cfg *Config) ([]HistoryEntry, error) {
+	f, err := os.Open(cfg.HistoryFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var history []HistoryEntry
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", cfg.HistoryFile(), n, err)
+		}
+		history = append(history, entry)
+	}
+	return history, scanner.Err()
+}
+
+// lastUpgrade returns the most recent upgrade entry that matches, or nil if there is none
+func lastUpgrade(history []HistoryEntry, match func(HistoryEntry) bool) *HistoryEntry {
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Action == HistoryUpgrade && match(history[i]) {
+			return &history[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+package cosmovisor
+
+import (
+	"log"
+	"os"
+)
+
+// Logger is used for cosmovisor's own messages, to keep them apart from the daemon's output
+var Logger = log.New(os.Stderr, "cosmovisor: ", log.LstdFlags)
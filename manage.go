@@ -0,0 +1,140 @@
+package cosmovisor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// UpgradeStatus describes a single entry in the upgrades directory
+type UpgradeStatus struct {
+	// BinErr is set if the binary for this upgrade is missing or not executable
+	BinErr  error
+	Name    string
+	Dir     string
+	Bin     string
+	Current bool
+}
+
+// CurrentUpgradeName returns the name of the upgrade the current link points to.
+// It returns "genesis" if the link points to the genesis dir or isn't set yet.
+// Unlike CurrentBin, this never creates the link.
+func (cfg *Config) CurrentUpgradeName() (string, error) {
+	cur := filepath.Join(cfg.Root(), currentLink)
+	info, err := os.Lstat(cur)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return genesisDir, nil
+	}
+
+	dest, err := os.Readlink(cur)
+	if err != nil {
+		return "", fmt.Errorf("reading current link: %w", err)
+	}
+
+	if filepath.Clean(dest) == filepath.Join(cfg.Root(), genesisDir) {
+		return genesisDir, nil
+	}
+	if filepath.Dir(filepath.Clean(dest)) != filepath.Join(cfg.Root(), upgradesDir) {
+		return "", fmt.Errorf("current link points outside of %s: %s", cfg.Root(), dest)
+	}
+
+	return url.PathUnescape(filepath.Base(dest))
+}
+
+// ListUpgrades returns every upgrade found in the upgrades dir, sorted by name.
+// A missing upgrades dir is not an error, it just means there are no upgrades yet.
+func ListUpgrades(cfg *Config) ([]UpgradeStatus, error) {
+	current, err := cfg.CurrentUpgradeName()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(filepath.Join(cfg.Root(), upgradesDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading upgrades dir: %w", err)
+	}
+
+	upgrades := make([]UpgradeStatus, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		name, err := url.PathUnescape(entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("invalid upgrade dir name %s: %w", entry.Name(), err)
+		}
+		bin := cfg.UpgradeBin(name)
+		upgrades = append(upgrades, UpgradeStatus{
+			BinErr:  EnsureBinary(bin),
+			Name:    name,
+			Dir:     cfg.UpgradeDir(name),
+			Bin:     bin,
+			Current: name == current,
+		})
+	}
+
+	sort.Slice(upgrades, func(i, j int) bool { return upgrades[i].Name < upgrades[j].Name })
+	return upgrades, nil
+}
+
+// AddUpgrade copies the binary at src into the bin dir of the named upgrade and marks it executable.
+// It refuses to replace an existing binary unless force is set.
+func AddUpgrade(cfg *Config, upgradeName, src string, force bool) error {
+	if upgradeName == "" {
+		return errors.New("upgrade name cannot be empty")
+	}
+	if upgradeName == genesisDir {
+		return errors.New("cannot add an upgrade named genesis")
+	}
+
+	info, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("cannot stat binary %s: %w", src, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", src)
+	}
+
+	bin := cfg.UpgradeBin(upgradeName)
+	if _, err := os.Stat(bin); err == nil && !force {
+		return fmt.Errorf("binary already exists for upgrade %s, won't overwrite", upgradeName)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(bin), 0755); err != nil {
+		return fmt.Errorf("creating upgrade dir: %w", err)
+	}
+	if err := copyFile(src, bin, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("copying binary: %w", err)
+	}
+	return MarkExecutable(bin)
+}
+
+// copyFile copies src to dst, replacing dst if it exists.
+// An existing dst is removed rather than truncated, as it may be a hardlink or a running binary.
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
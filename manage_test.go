@@ -0,0 +1,70 @@
+package cosmovisor_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/provenance-io/cosmovisor"
+)
+
+func (s *upgradeTestSuite) TestCurrentUpgradeName() {
+	home := copyTestData(s.T(), "validate")
+	cfg := &cosmovisor.Config{Home: home, Name: "dummyd"}
+
+	// no link yet, so this is genesis and no link should be created
+	name, err := cfg.CurrentUpgradeName()
+	s.Require().NoError(err)
+	s.Require().Equal("genesis", name)
+	_, err = os.Lstat(filepath.Join(cfg.Root(), "current"))
+	s.Require().True(os.IsNotExist(err))
+
+	s.Require().NoError(cfg.SetCurrentUpgrade("chain2"))
+	name, err = cfg.CurrentUpgradeName()
+	s.Require().NoError(err)
+	s.Require().Equal("chain2", name)
+}
+
+func (s *upgradeTestSuite) TestListUpgrades() {
+	home := copyTestData(s.T(), "validate")
+	cfg := &cosmovisor.Config{Home: home, Name: "dummyd"}
+	s.Require().NoError(cfg.SetCurrentUpgrade("chain3"))
+
+	upgrades, err := cosmovisor.ListUpgrades(cfg)
+	s.Require().NoError(err)
+
+	names := make([]string, len(upgrades))
+	for i, up := range upgrades {
+		names[i] = up.Name
+		s.Require().Equal(up.Name == "chain3", up.Current, up.Name)
+		s.Require().Equal(up.Name == "chain2" || up.Name == "chain3", up.BinErr == nil, up.Name)
+	}
+	s.Require().Equal([]string{"chain2", "chain3", "nobin", "noexec"}, names)
+
+	// no upgrades dir at all is fine
+	empty := &cosmovisor.Config{Home: s.T().TempDir(), Name: "dummyd"}
+	upgrades, err = cosmovisor.ListUpgrades(empty)
+	s.Require().NoError(err)
+	s.Require().Empty(upgrades)
+}
+
+func (s *upgradeTestSuite) TestAddUpgrade() {
+	home := copyTestData(s.T(), "validate")
+	cfg := &cosmovisor.Config{Home: home, Name: "dummyd"}
+	src := cfg.UpgradeBin("chain2")
+
+	s.Require().Error(cosmovisor.AddUpgrade(cfg, "", src, false))
+	s.Require().Error(cosmovisor.AddUpgrade(cfg, "genesis", src, false))
+	s.Require().Error(cosmovisor.AddUpgrade(cfg, "chain4", filepath.Join(home, "no-such-file"), false))
+
+	s.Require().NoError(cosmovisor.AddUpgrade(cfg, "chain4", src, false))
+	s.Require().NoError(cosmovisor.EnsureBinary(cfg.UpgradeBin("chain4")))
+	s.Require().NoError(cfg.SetCurrentUpgrade("chain4"))
+
+	// existing binary is only replaced with force
+	s.Require().Error(cosmovisor.AddUpgrade(cfg, "chain4", src, false))
+	s.Require().NoError(cosmovisor.AddUpgrade(cfg, "chain4", src, true))
+
+	// a non-executable source still ends up executable
+	s.Require().NoError(cosmovisor.AddUpgrade(cfg, "from-noexec", cfg.UpgradeBin("noexec"), false))
+	s.Require().NoError(cosmovisor.EnsureBinary(cfg.UpgradeBin("from-noexec")))
+}
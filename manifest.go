@@ -0,0 +1,372 @@
+package cosmovisor
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/provenance-io/cosmovisor/version"
+)
+
+const (
+	manifestFile = "manifest.json"
+	// maxManifestDiffs is how many differences a failed verification lists
+	maxManifestDiffs = 5
+)
+
+// Manifest lists the files of a data backup, it is written next to the backup before the .keep stamp
+type Manifest struct {
+	Upgrade string       `json:"upgrade"`
+	Height  int64        `json:"height,omitempty"`
+	Format  BackupFormat `json:"format"`
+	Created time.Time    `json:"created"`
+	// CosmovisorVersion is the version of cosmovisor that took the backup
+	CosmovisorVersion string `json:"cosmovisor_version,omitempty"`
+	// Binary is the daemon binary that produced the data, the one being upgraded from
+	Binary       string `json:"binary,omitempty"`
+	BinarySHA256 string `json:"binary_sha256,omitempty"`
+	// Partial is set when parts of the data dir were left out by the Excluded patterns,
+	// except what the Included patterns took back, a restore lacks them
+	Partial  bool     `json:"partial,omitempty"`
+	Excluded []string `json:"excluded,omitempty"`
+	Included []string `json:"included,omitempty"`
+	// Files and Bytes are the number and total size of the regular files in the data dir
+	Files   int             `json:"files"`
+	Bytes   int64           `json:"bytes"`
+	Entries []ManifestEntry `json:"entries"`
+	// ExtraPaths are the paths backed up besides the data dir, relative to the node home,
+	// Extra lists their files with paths relative to the node home too
+	ExtraPaths []string        `json:"extra_paths,omitempty"`
+	Extra      []ManifestEntry `json:"extra,omitempty"`
+}
+
+// ManifestEntry is a single regular file of a backup, its path is relative to the data dir and uses slashes
+type ManifestEntry struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// Mode holds the permission bits in octal, e.g. 0644
+	Mode   string `json:"mode,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// VerifyResult lists how a backup differs from its manifest
+type VerifyResult struct {
+	// Files is how many files were checked
+	Files   int
+	Missing []string
+	Extra   []string
+	// Corrupt holds the files whose size, mode or checksum differ, with what differs
+	Corrupt []string
+}
+
+// OK is true if the backup matches its manifest
+func (r *VerifyResult) OK() bool {
+	return len(r.Missing) == 0 && len(r.Extra) == 0 && len(r.Corrupt) == 0
+}
+
+// String summarizes the differences, listing the first few of them
+func (r *VerifyResult) String() string {
+	if r.OK() {
+		return fmt.Sprintf("%d files ok", r.Files)
+	}
+	var diffs []string
+	for _, path := range r.Missing {
+		diffs = append(diffs, "missing "+path)
+	}
+	for _, path := range r.Extra {
+		diffs = append(diffs, "unexpected "+path)
+	}
+	diffs = append(diffs, r.Corrupt...)
+	if len(diffs) > maxManifestDiffs {
+		diffs = append(diffs[:maxManifestDiffs], fmt.Sprintf("and %d more", len(diffs)-maxManifestDiffs))
+	}
+	return fmt.Sprintf("%d missing, %d unexpected and %d corrupt files: %s",
+		len(r.Missing), len(r.Extra), len(r.Corrupt), strings.Join(diffs, ", "))
+}
+
+// newManifestEntry describes a regular file, reading it through for its checksum if hash is set
+func newManifestEntry(rel string, info os.FileInfo, r io.Reader, hash bool) (ManifestEntry, error) {
+	e := ManifestEntry{Path: filepath.ToSlash(rel), Size: info.Size(), Mode: fmt.Sprintf("%04o", info.Mode().Perm())}
+	if hash {
+		h := sha256.New()
+		if _, err := io.Copy(h, r); err != nil {
+			return e, err
+		}
+		e.SHA256 = hex.EncodeToString(h.Sum(nil))
+	}
+	return e, nil
+}
+
+// buildManifest lists the regular files below dir that filter takes, with their checksums if hash is set
+func buildManifest(dir string, hash bool, filter *dataFilter) (*Manifest, error) {
+	m := &Manifest{}
+	err := walkData(dir, filter, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		var e ManifestEntry
+		if hash {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			e, err = newManifestEntry(rel, info, f, true)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("hashing %s: %w", rel, err)
+			}
+		} else if e, err = newManifestEntry(rel, info, nil, false); err != nil {
+			return err
+		}
+		m.add(e)
+		return nil
+	})
+	return m, err
+}
+
+// archiveManifest lists the regular files in an archive written by writeArchive, hashing them as they are read
+func archiveManifest(archive string, format BackupFormat) (*Manifest, error) {
+	tr, close, err := openArchive(archive, format)
+	if err != nil {
+		return nil, err
+	}
+	defer close()
+
+	m := &Manifest{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return m, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		e, err := newManifestEntry(filepath.FromSlash(hdr.Name), hdr.FileInfo(), tr, true)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", hdr.Name, err)
+		}
+		m.add(e)
+	}
+}
+
+// manifestRecorder collects the files a backup writes, with the size and checksum of the bytes actually written,
+// so the manifest describes the backup rather than the data dir at some other moment.
+// It is safe for concurrent use by the copy workers, a nil recorder records nothing.
+type manifestRecorder struct {
+	mu      sync.Mutex
+	entries []ManifestEntry
+}
+
+// record adds the file at rel, whose info is that of its source, written as size bytes with the sha256 sum
+func (r *manifestRecorder) record(rel string, info os.FileInfo, size int64, sum []byte) {
+	if r == nil {
+		return
+	}
+	e := ManifestEntry{Path: filepath.ToSlash(rel), Size: size, Mode: fmt.Sprintf("%04o", info.Mode().Perm()), SHA256: hex.EncodeToString(sum)}
+	r.mu.Lock()
+	r.entries = append(r.entries, e)
+	r.mu.Unlock()
+}
+
+// sorted returns the recorded entries in path order, as a walk of the tree lists them
+func (r *manifestRecorder) sorted() []ManifestEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sort.Slice(r.entries, func(i, j int) bool { return r.entries[i].Path < r.entries[j].Path })
+	return r.entries
+}
+
+// add appends an entry and counts it in the totals
+func (m *Manifest) add(e ManifestEntry) {
+	m.Entries = append(m.Entries, e)
+	m.Files++
+	m.Bytes += e.Size
+}
+
+// writeManifest writes m into backupDir and syncs it to disk
+func writeManifest(backupDir string, m *Manifest) error {
+	bz, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(backupDir, manifestFile), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(bz); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadManifest reads the manifest of the backup in backupDir, it returns nil, nil for backups taken without one
+func ReadManifest(backupDir string) (*Manifest, error) {
+	bz, err := os.ReadFile(filepath.Join(backupDir, manifestFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(bz, &m); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", filepath.Join(backupDir, manifestFile), err)
+	}
+	return &m, nil
+}
+
+// compare lists how found differs from the manifest.
+// Modes and checksums are only compared where both sides have them.
+func (m *Manifest) compare(found *Manifest) *VerifyResult {
+	r := &VerifyResult{Files: found.Files}
+	expected := make(map[string]ManifestEntry, len(m.Entries))
+	for _, e := range m.Entries {
+		expected[e.Path] = e
+	}
+	for _, e := range found.Entries {
+		want, ok := expected[e.Path]
+		delete(expected, e.Path)
+		switch {
+		case !ok:
+			r.Extra = append(r.Extra, e.Path)
+		case want.Size != e.Size:
+			r.Corrupt = append(r.Corrupt, fmt.Sprintf("%s is %d bytes, expected %d", e.Path, e.Size, want.Size))
+		case want.SHA256 != "" && e.SHA256 != "" && want.SHA256 != e.SHA256:
+			r.Corrupt = append(r.Corrupt, fmt.Sprintf("%s has sha256 %s, expected %s", e.Path, e.SHA256, want.SHA256))
+		case want.Mode != "" && e.Mode != "" && want.Mode != e.Mode:
+			r.Corrupt = append(r.Corrupt, fmt.Sprintf("%s has mode %s, expected %s", e.Path, e.Mode, want.Mode))
+		}
+	}
+	for path := range expected {
+		r.Missing = append(r.Missing, path)
+	}
+	sort.Strings(r.Missing)
+	sort.Strings(r.Extra)
+	sort.Strings(r.Corrupt)
+	return r
+}
+
+// Verify checks that dir holds the files of the manifest with the same sizes and modes, and nothing else.
+// Checksums are not compared, VerifyBackup does that.
+func (m *Manifest) Verify(dir string) error {
+	found, err := buildManifest(dir, false, nil)
+	if err != nil {
+		return err
+	}
+	if r := m.compare(found); !r.OK() {
+		return fmt.Errorf("%s has %s", dir, r)
+	}
+	return nil
+}
+
+// VerifyBackup re-reads the backup taken before the named upgrade, without restoring it,
+// and compares every file with its size, mode and checksum in the manifest.
+func VerifyBackup(cfg *Config, upgradeName string) (*VerifyResult, error) {
+	backupDir := cfg.BackupDir(upgradeName)
+	if _, err := os.Stat(filepath.Join(backupDir, ".keep")); err != nil {
+		return nil, fmt.Errorf("no complete backup for upgrade %s in %s", upgradeName, backupDir)
+	}
+	manifest, err := ReadManifest(backupDir)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return nil, fmt.Errorf("backup of %s has no manifest to verify against", upgradeName)
+	}
+
+	var found *Manifest
+	for _, format := range archiveFormats {
+		archive := backupArchive(backupDir, format)
+		if _, err := os.Stat(archive); err == nil {
+			if found, err = archiveManifest(archive, format); err != nil {
+				return nil, fmt.Errorf("reading %s: %w", archive, err)
+			}
+			break
+		}
+	}
+	if found == nil {
+		if found, err = buildManifest(filepath.Join(backupDir, "data"), true, nil); err != nil {
+			return nil, err
+		}
+	}
+	result := manifest.compare(found)
+	if len(manifest.ExtraPaths) == 0 {
+		return result, nil
+	}
+
+	// the extra paths are reported as they are in the backup dir, below home/
+	found, err = buildManifest(filepath.Join(backupDir, backupHomeDir), true, nil)
+	if err != nil {
+		return nil, err
+	}
+	extra := (&Manifest{Entries: manifest.Extra}).compare(found)
+	inHome := func(paths []string) []string {
+		for i, p := range paths {
+			paths[i] = backupHomeDir + "/" + p
+		}
+		return paths
+	}
+	result.Files += extra.Files
+	result.Missing = append(result.Missing, inHome(extra.Missing)...)
+	result.Extra = append(result.Extra, inHome(extra.Extra)...)
+	result.Corrupt = append(result.Corrupt, inHome(extra.Corrupt)...)
+	return result, nil
+}
+
+// newBackupManifest describes what the backup of cfg is for and which extra paths it takes,
+// the files are added as the backup writes them
+func newBackupManifest(cfg *Config, info *UpgradeInfo) *Manifest {
+	m := &Manifest{Upgrade: info.Name, Height: info.Height, Created: time.Now().UTC(), Format: cfg.BackupFormat}
+	if m.Format == "" {
+		m.Format = BackupCopy
+	}
+	if filter := cfg.backupFilter(); filter != nil {
+		m.Partial, m.Excluded, m.Included = true, filter.exclude, filter.include
+	}
+	for _, rel := range cfg.BackupExtraPaths {
+		rel = filepath.Clean(rel)
+		if _, err := os.Lstat(filepath.Join(cfg.Home, rel)); os.IsNotExist(err) {
+			Logger.Printf("not backing up %s: it doesn't exist", filepath.Join(cfg.Home, rel))
+			continue
+		}
+		m.ExtraPaths = append(m.ExtraPaths, rel)
+	}
+	m.CosmovisorVersion = version.Version
+	if version.Commit != "" {
+		m.CosmovisorVersion += " (" + version.Commit + ")"
+	}
+
+	m.Binary = cfg.GenesisBin()
+	if current, err := cfg.CurrentUpgradeName(); err == nil && current != genesisDir {
+		m.Binary = cfg.UpgradeBin(current)
+	}
+	if sum, err := hashFile(m.Binary, sha256.New()); err == nil {
+		m.BinarySHA256 = hex.EncodeToString(sum)
+	}
+	return m
+}
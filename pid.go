@@ -0,0 +1,52 @@
+package cosmovisor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const pidFile = "daemon.pid"
+
+// ErrDaemonRunning is returned by operations that need the daemon to be stopped
+var ErrDaemonRunning = errors.New("the daemon is running")
+
+// PIDFile holds the pid of cosmovisor while it supervises the daemon, or of the daemon while Launch runs it on its own
+func (cfg *Config) PIDFile() string {
+	return filepath.Join(cfg.Root(), pidFile)
+}
+
+// writePIDFile records the pid of the process that owns the home dir
+func writePIDFile(cfg *Config, pid int) error {
+	return os.WriteFile(cfg.PIDFile(), []byte(strconv.Itoa(pid)+"\n"), 0644)
+}
+
+// removePIDFile removes the pid file once its process is done with the home dir
+func removePIDFile(cfg *Config) {
+	if err := os.Remove(cfg.PIDFile()); err != nil && !os.IsNotExist(err) {
+		Logger.Printf("removing pid file: %v", err)
+	}
+}
+
+// checkNotRunning returns an error wrapping ErrDaemonRunning if the pid file names a live process.
+// A pid file left behind by a process that is gone is ignored.
+func checkNotRunning(cfg *Config) error {
+	bz, err := os.ReadFile(cfg.PIDFile())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(bz)))
+	if err != nil || pid <= 0 {
+		return fmt.Errorf("invalid pid file %s: %q", cfg.PIDFile(), bz)
+	}
+	if processAlive(pid) {
+		return fmt.Errorf("%w as pid %d (from %s), stop it first", ErrDaemonRunning, pid, cfg.PIDFile())
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package cosmovisor
+
+import "os"
+
+// processAlive reports whether a process with the given pid exists
+func processAlive(pid int) bool {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	_ = proc.Release()
+	return true
+}
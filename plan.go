@@ -0,0 +1,212 @@
+package cosmovisor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	currentPlanPath  = "/cosmos/upgrade/v1beta1/current_plan"
+	latestBlockPath  = "/cosmos/base/tendermint/v1beta1/blocks/latest"
+	planQueryTimeout = 10 * time.Second
+	// defaultPlanPollInterval is used when the config doesn't set a plan poll interval
+	defaultPlanPollInterval = 30 * time.Second
+)
+
+// prepareMutex makes sure an upgrade is only downloaded once at a time,
+// as the plan poller may still be preparing it when the node halts for it
+var prepareMutex sync.Mutex
+
+// PlanStatus is what the node's REST endpoint said about the pending upgrade
+type PlanStatus struct {
+	// Plan is the pending upgrade plan, nil if there is none
+	Plan *UpgradeInfo
+	// Height is the latest block height of the node
+	Height int64
+}
+
+// BlocksRemaining returns how many blocks are left until a height based plan is due
+func (s PlanStatus) BlocksRemaining() int64 {
+	if s.Plan == nil || s.Plan.Height == 0 {
+		return 0
+	}
+	return s.Plan.Height - s.Height
+}
+
+// String describes the status for logs
+func (s PlanStatus) String() string {
+	switch {
+	case s.Plan == nil:
+		return fmt.Sprintf("no upgrade planned at height %d", s.Height)
+	case s.Plan.Height == 0:
+		return fmt.Sprintf("upgrade %q is due at %s, in %s", s.Plan.Name, s.Plan.Time.UTC().Format(time.RFC3339),
+			time.Until(s.Plan.Time).Round(time.Second))
+	default:
+		return fmt.Sprintf("upgrade %q is due at height %d, %d blocks from %d", s.Plan.Name, s.Plan.Height, s.BlocksRemaining(), s.Height)
+	}
+}
+
+// PlanPoller polls the node's REST endpoint for the pending upgrade plan, and prepares its binary as soon as it shows up
+type PlanPoller struct {
+	cfg    *Config
+	client *http.Client
+	// prepared is the plan last prepared, so it is only downloaded once.
+	// A plan changed under the same name is prepared again.
+	prepared *UpgradeInfo
+	// polled is set once a poll succeeded, and plan holds what it found, so only changes are logged
+	polled bool
+	plan   *UpgradeInfo
+	// lastErr is the error of the last poll, so a node that stays down is only reported once
+	lastErr string
+}
+
+// NewPlanPoller returns a PlanPoller for the endpoint in cfg
+func NewPlanPoller(cfg *Config) *PlanPoller {
+	return &PlanPoller{cfg: cfg, client: &http.Client{Timeout: planQueryTimeout}}
+}
+
+// Run polls the endpoint every PlanPollInterval until ctx is done.
+// Each new plan is prepared with PrepareUpgrade if downloading binaries is allowed, and failures are retried on the next poll.
+func (p *PlanPoller) Run(ctx context.Context) {
+	interval := p.cfg.PlanPollInterval
+	if interval <= 0 {
+		interval = defaultPlanPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.pollOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollOnce does a single poll, logging the plan when it changed and preparing a new plan.
+// Preparing stops once ctx is done.
+func (p *PlanPoller) pollOnce(ctx context.Context) {
+	status, err := p.Poll(ctx)
+	if err != nil {
+		if ctx.Err() == nil && err.Error() != p.lastErr {
+			Logger.Printf("polling %s for the upgrade plan: %v", p.cfg.PlanEndpoint, err)
+			p.lastErr = err.Error()
+		}
+		return
+	}
+	p.lastErr = ""
+	changed := !samePlan(p.plan, status.Plan)
+	// no plan is only worth saying when one went away
+	if changed && (status.Plan != nil || p.polled) {
+		Logger.Print(status)
+	}
+	p.polled, p.plan = true, status.Plan
+	if status.Plan == nil {
+		return
+	}
+
+	name := status.Plan.Name
+	if samePlan(p.prepared, status.Plan) || !p.cfg.AllowDownloadBinaries {
+		return
+	}
+	if current, err := p.cfg.CurrentUpgradeName(); err == nil && current == name {
+		p.prepared = status.Plan
+		return
+	}
+	Logger.Printf("preparing upgrade %q", name)
+	if err := prepareUpgrade(ctx, p.cfg, status.Plan); err != nil {
+		if ctx.Err() == nil {
+			Logger.Printf("preparing upgrade %q: %v", name, err)
+		}
+		return
+	}
+	p.prepared = status.Plan
+	Logger.Printf("prepared upgrade %q: %s", name, p.cfg.UpgradeBin(name))
+}
+
+// samePlan reports whether a and b are the same plan, or both nil
+func samePlan(a, b *UpgradeInfo) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Name == b.Name && a.Height == b.Height && a.Time.Equal(b.Time) && a.Info == b.Info
+}
+
+// Poll asks the node for the pending plan and its latest height
+func (p *PlanPoller) Poll(ctx context.Context) (PlanStatus, error) {
+	var status PlanStatus
+
+	var planResp struct {
+		Plan *struct {
+			Time   time.Time `json:"time"`
+			Name   string    `json:"name"`
+			Info   string    `json:"info"`
+			Height string    `json:"height"`
+		} `json:"plan"`
+	}
+	if err := p.get(ctx, currentPlanPath, &planResp); err != nil {
+		return status, err
+	}
+	if plan := planResp.Plan; plan != nil && plan.Name != "" {
+		height, err := parseHeight(plan.Height)
+		if err != nil {
+			return status, fmt.Errorf("parsing plan height: %w", err)
+		}
+		status.Plan = &UpgradeInfo{Name: plan.Name, Info: plan.Info, Height: height}
+		if height == 0 {
+			status.Plan.Time = plan.Time
+		}
+	}
+
+	var blockResp struct {
+		Block struct {
+			Header struct {
+				Height string `json:"height"`
+			} `json:"header"`
+		} `json:"block"`
+	}
+	if err := p.get(ctx, latestBlockPath, &blockResp); err != nil {
+		return status, err
+	}
+	height, err := parseHeight(blockResp.Block.Header.Height)
+	if err != nil {
+		return status, fmt.Errorf("parsing block height: %w", err)
+	}
+	status.Height = height
+	return status, nil
+}
+
+// get queries a REST path of the endpoint and decodes the json response into v
+func (p *PlanPoller) get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.cfg.PlanEndpoint, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: bad response code: %d", path, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// parseHeight parses a height as the REST api returns it, a string holding a number, or empty for 0
+func parseHeight(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
@@ -0,0 +1,223 @@
+package cosmovisor_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/provenance-io/cosmovisor"
+)
+
+// fakeNode stands in for the REST endpoint of a node, and serves the upgrade binary too
+type fakeNode struct {
+	mutex  sync.Mutex
+	plan   string
+	height int64
+	binary []byte
+	// downloads counts the requests for the binary
+	downloads int
+}
+
+func (n *fakeNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	switch r.URL.Path {
+	case "/cosmos/upgrade/v1beta1/current_plan":
+		plan := n.plan
+		if plan == "" {
+			plan = "null"
+		}
+		fmt.Fprintf(w, `{"plan":%s}`, plan)
+	case "/cosmos/base/tendermint/v1beta1/blocks/latest":
+		fmt.Fprintf(w, `{"block_id":{"hash":"AAAA"},"block":{"header":{"chain_id":"testing","height":"%d"}}}`, n.height)
+	case "/autod":
+		n.downloads++
+		_, _ = w.Write(n.binary)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (n *fakeNode) set(plan string, height int64) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.plan, n.height = plan, height
+}
+
+func (n *fakeNode) downloaded() int {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.downloads
+}
+
+func TestPlanPollerPoll(t *testing.T) {
+	node := &fakeNode{}
+	server := httptest.NewServer(node)
+	defer server.Close()
+	poller := cosmovisor.NewPlanPoller(&cosmovisor.Config{PlanEndpoint: server.URL + "/"})
+
+	node.set("", 90)
+	status, err := poller.Poll(context.Background())
+	require.NoError(t, err)
+	require.Nil(t, status.Plan)
+	require.Equal(t, int64(90), status.Height)
+
+	node.set(`{"name":"v2","time":"0001-01-01T00:00:00Z","height":"100","info":"{}","upgraded_client_state":null}`, 95)
+	status, err = poller.Poll(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, &cosmovisor.UpgradeInfo{Name: "v2", Height: 100, Info: "{}"}, status.Plan)
+	require.Equal(t, int64(5), status.BlocksRemaining())
+	require.Equal(t, `upgrade "v2" is due at height 100, 5 blocks from 95`, status.String())
+
+	node.set(`{"name":"v3","time":"2030-01-01T00:00:00Z","height":"0","info":""}`, 95)
+	status, err = poller.Poll(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "v3", status.Plan.Name)
+	require.Equal(t, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), status.Plan.Time)
+	require.Equal(t, int64(0), status.BlocksRemaining())
+
+	node.set(`{"name":"v4","height":"soon"}`, 95)
+	_, err = poller.Poll(context.Background())
+	require.Error(t, err)
+}
+
+func TestPlanPollerPrepares(t *testing.T) {
+	binary, err := os.ReadFile("testdata/repo/raw_binary/autod")
+	require.NoError(t, err)
+	node := &fakeNode{binary: binary}
+	server := httptest.NewServer(node)
+	defer server.Close()
+
+	home := copyTestData(t, "download")
+	cfg := &cosmovisor.Config{
+		Home:                  home,
+		Name:                  "autod",
+		AllowDownloadBinaries: true,
+		PlanEndpoint:          server.URL,
+		PlanPollInterval:      10 * time.Millisecond,
+	}
+	info := fmt.Sprintf(`{\"binaries\":{\"any\":\"%s/autod\"}}`, server.URL)
+	node.set(fmt.Sprintf(`{"name":"amazonas","height":"100","info":"%s"}`, info), 90)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cosmovisor.NewPlanPoller(cfg).Run(ctx)
+	}()
+
+	require.Eventually(t, func() bool {
+		return cosmovisor.EnsureBinary(cfg.UpgradeBin("amazonas")) == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	// a few more polls must not download it again
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, 1, node.downloaded())
+
+	// but the plan changed under the same name is prepared again
+	require.NoError(t, os.RemoveAll(cfg.UpgradeDir("amazonas")))
+	node.set(fmt.Sprintf(`{"name":"amazonas","height":"110","info":"%s"}`, info), 95)
+	require.Eventually(t, func() bool {
+		return cosmovisor.EnsureBinary(cfg.UpgradeBin("amazonas")) == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+	require.Equal(t, 2, node.downloaded())
+
+	// the halt then only switches the link
+	cfg.AllowDownloadBinaries = false
+	require.NoError(t, cosmovisor.DoUpgrade(cfg, &cosmovisor.UpgradeInfo{Name: "amazonas", Height: 100}))
+	current, err := cfg.CurrentUpgradeName()
+	require.NoError(t, err)
+	require.Equal(t, "amazonas", current)
+}
+
+func TestPlanPollerLogsChanges(t *testing.T) {
+	node := &fakeNode{}
+	server := httptest.NewServer(node)
+	defer server.Close()
+	var logs bytes.Buffer
+	defer cosmovisor.Logger.SetOutput(cosmovisor.Logger.Writer())
+	cosmovisor.Logger.SetOutput(&logs)
+
+	cfg := &cosmovisor.Config{Home: t.TempDir(), PlanEndpoint: server.URL, PlanPollInterval: 5 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cosmovisor.NewPlanPoller(cfg).Run(ctx)
+	}()
+	step := func(plan string, height int64) {
+		node.set(plan, height)
+		time.Sleep(50 * time.Millisecond)
+	}
+	step("", 90)
+	step(`{"name":"v2","height":"100","info":"{}"}`, 91)
+	step(`{"name":"v2","height":"100","info":"{}"}`, 92)
+	step("", 93)
+	cancel()
+	<-done
+
+	// every poll saw a new height, but only the plan showing up and going away are logged
+	require.Equal(t, 1, strings.Count(logs.String(), `upgrade "v2" is due at height 100`), logs.String())
+	require.Equal(t, 1, strings.Count(logs.String(), "no upgrade planned"), logs.String())
+}
+
+func TestPlanPollerStopsPreparing(t *testing.T) {
+	// the binary never finishes downloading
+	started := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cosmos/upgrade/v1beta1/current_plan":
+			fmt.Fprintf(w, `{"plan":{"name":"amazonas","height":"100","info":"{\"binaries\":{\"any\":\"http://%s/autod\"}}"}}`, r.Host)
+		case "/cosmos/base/tendermint/v1beta1/blocks/latest":
+			fmt.Fprint(w, `{"block":{"header":{"height":"90"}}}`)
+		case "/autod":
+			w.Header().Set("Content-Length", "1000")
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			select {
+			case started <- struct{}{}:
+			default:
+			}
+			select {
+			case <-r.Context().Done():
+			case <-time.After(10 * time.Second):
+			}
+		}
+	}))
+	defer server.Close()
+
+	cfg := &cosmovisor.Config{
+		Home:                  copyTestData(t, "download"),
+		Name:                  "autod",
+		AllowDownloadBinaries: true,
+		PlanEndpoint:          server.URL,
+		PlanPollInterval:      time.Hour,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cosmovisor.NewPlanPoller(cfg).Run(ctx)
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the download didn't start")
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelling didn't stop the download")
+	}
+}
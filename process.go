@@ -2,67 +2,21 @@ package cosmovisor
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"os/exec"
-	"os/signal"
-	"strings"
 	"sync"
 	"syscall"
+	"time"
 )
 
 // LaunchProcess runs a subprocess and returns when the subprocess exits,
 // either when it dies, or *after* a successful upgrade.
+// It doesn't handle any signals, use a Supervisor to run the daemon for real.
 func LaunchProcess(cfg *Config, args []string, stdout, stderr io.Writer) (bool, error) {
-	bin, err := cfg.CurrentBin()
-	if err != nil {
-		return false, fmt.Errorf("error creating symlink to genesis: %w", err)
-	}
-
-	if e := EnsureBinary(bin); e != nil {
-		return false, fmt.Errorf("current binary invalid: %w", e)
-	}
-
-	cmd := exec.Command(bin, args...)
-	outpipe, e := cmd.StdoutPipe()
-	if e != nil {
-		return false, e
-	}
-
-	errpipe, e := cmd.StderrPipe()
-	if e != nil {
-		return false, e
-	}
-
-	scanOut := bufio.NewScanner(io.TeeReader(outpipe, stdout))
-	scanErr := bufio.NewScanner(io.TeeReader(errpipe, stderr))
-
-	if e := cmd.Start(); e != nil {
-		return false, fmt.Errorf("launching process %s %s: %w", bin, strings.Join(args, " "), e)
-	}
-
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGQUIT, syscall.SIGTERM)
-	go func() {
-		sig := <-sigs
-		if ee := cmd.Process.Signal(sig); ee != nil {
-			log.Fatal(ee)
-		}
-	}()
-
-	// three ways to exit - command ends, find regexp in scanOut, find regexp in scanErr
-	upgradeInfo, err := WaitForUpgradeOrExit(cmd, scanOut, scanErr)
-	if err != nil {
-		return false, err
-	}
-
-	if upgradeInfo != nil {
-		return true, DoUpgrade(cfg, upgradeInfo)
-	}
-
-	return false, nil
+	return NewSupervisor(cfg, stdout, stderr).Launch(context.Background(), args)
 }
 
 // WaitResult is used to wrap feedback on cmd state with some mutex logic.
@@ -73,6 +27,7 @@ type WaitResult struct {
 	// access is wrapped by mutex and should only be done through methods
 	err   error
 	info  *UpgradeInfo
+	stop  *StopResult
 	mutex sync.Mutex
 }
 
@@ -104,37 +59,187 @@ func (u *WaitResult) SetUpgrade(up *UpgradeInfo) {
 	}
 }
 
+// SetStop records how the process was stopped for the upgrade
+func (u *WaitResult) SetStop(stop StopResult) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.stop = &stop
+}
+
+// Stop returns how the process was stopped for the upgrade, or nil if it wasn't
+func (u *WaitResult) Stop() *StopResult {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.stop
+}
+
+// StopResult records how the daemon was stopped once an upgrade was detected
+type StopResult struct {
+	Signal os.Signal
+	Grace  time.Duration
+	// Killed is true if the daemon didn't exit within the grace period and got SIGKILL
+	Killed bool
+}
+
+// String describes how the daemon was stopped for logs
+func (r StopResult) String() string {
+	switch {
+	case r.Grace == 0:
+		return "killed right away"
+	case r.Killed:
+		return fmt.Sprintf("sent %s, killed after %s grace period", r.Signal, r.Grace)
+	default:
+		return fmt.Sprintf("sent %s, exited within %s grace period", r.Signal, r.Grace)
+	}
+}
+
+// StopProcess stops the process with the configured shutdown signal, and kills it if it hasn't
+// exited within the grace period. exited must be closed once the process has exited.
+// Without a shutdown signal or grace period, the process is killed right away.
+func StopProcess(cfg *Config, proc *os.Process, exited <-chan struct{}) StopResult {
+	sig, err := parseSignal(cfg.ShutdownSignal)
+	if err != nil || sig == syscall.SIGKILL || cfg.ShutdownGrace <= 0 {
+		Logger.Printf("killing daemon")
+		_ = proc.Kill()
+		return StopResult{Signal: os.Kill, Killed: true}
+	}
+
+	res := StopResult{Signal: sig, Grace: cfg.ShutdownGrace}
+	Logger.Printf("stopping daemon with %s, waiting up to %s", sig, cfg.ShutdownGrace)
+	if err := proc.Signal(sig); err != nil {
+		// most likely it has exited already, but make sure
+		_ = proc.Kill()
+		return res
+	}
+
+	timer := time.NewTimer(cfg.ShutdownGrace)
+	defer timer.Stop()
+	select {
+	case <-exited:
+	case <-timer.C:
+		Logger.Printf("daemon did not exit within %s after %s, killing it", cfg.ShutdownGrace, sig)
+		_ = proc.Kill()
+		res.Killed = true
+	}
+	return res
+}
+
 // WaitForUpgradeOrExit listens to both output streams of the process, as well as the process state itself
-// When it returns, the process is finished and all streams have closed.
+// When it returns, the process is finished and all streams have closed, or have had pipeDrainTimeout to do so.
+// Once an upgrade is detected, the process is stopped as configured by StopProcess.
 //
-// It returns (info, nil) if an upgrade should be initiated (and we killed the process)
+// It returns (info, nil) if an upgrade should be initiated (and we stopped the process)
 // It returns (nil, err) if the process died by itself, or there was an issue reading the pipes
 // It returns (nil, nil) if the process exited normally without triggering an upgrade. This is very unlikely
 // to happened with "start" but may happened with short-lived commands like `gaiad export ...`
-func WaitForUpgradeOrExit(cmd *exec.Cmd, scanOut, scanErr *bufio.Scanner) (*UpgradeInfo, error) {
+func WaitForUpgradeOrExit(cfg *Config, cmd *exec.Cmd, scanOut, scanErr *bufio.Scanner) (*UpgradeInfo, error) {
 	res := WaitResult{}
-	waitScan := func(scan *bufio.Scanner) {
-		upgrade, err := WaitForUpdate(scan)
+	exited := make(chan struct{})
+	stopped := make(chan struct{})
+	var stopOnce sync.Once
+
+	// each stream needs its own detector, as they keep state between lines
+	detectOut, err := NewDetector(cfg)
+	if err != nil {
+		return nil, killForError(cmd, WithExitCode(ExitCodeConfig, err))
+	}
+	detectErr, _ := NewDetector(cfg)
+
+	// whichever detects the upgrade first, the logs or the upgrade-info.json watcher, wins
+	upgradeFound := func(upgrade *UpgradeInfo) {
+		res.SetUpgrade(upgrade)
+		go stopOnce.Do(func() {
+			res.SetStop(StopProcess(cfg, cmd.Process, exited))
+			close(stopped)
+		})
+	}
+
+	watching := make(chan struct{})
+	go func() {
+		defer close(watching)
+		if !cfg.WatchUpgradeInfo {
+			return
+		}
+		upgrade, err := WatchUpgradeInfoFile(cfg, exited)
+		if err != nil {
+			Logger.Printf("not watching %s: %v", cfg.UpgradeInfoFile(), err)
+		}
+		if upgrade != nil {
+			upgradeFound(upgrade)
+		}
+	}()
+
+	var scanning sync.WaitGroup
+	scanning.Add(2)
+	waitScan := func(scan *bufio.Scanner, detector Detector) {
+		defer scanning.Done()
+		upgrade, err := DetectUpgrade(scan, detector)
 		if err != nil {
 			res.SetError(err)
 		}
 		if upgrade != nil {
-			res.SetUpgrade(upgrade)
-			_ = cmd.Process.Kill()
+			upgradeFound(upgrade)
+		}
+		// keep reading, so the daemon doesn't block on a full pipe, whether it is shutting down or carries on
+		for scan.Scan() {
 		}
 	}
-	// wait for the scanners, which can trigger upgrade and kill cmd
-	go waitScan(scanOut)
-	go waitScan(scanErr)
+	// wait for the scanners, which can trigger upgrade and stop cmd
+	go waitScan(scanOut, detectOut)
+	go waitScan(scanErr, detectErr)
 
 	// if the command exits normally (eg. short command like `gaiad version`), just return (nil, nil)
 	// we often get broken read pipes if it runs too fast.
-	// if we had upgrade info, we would have killed it, and thus got a non-nil error code
-	err := cmd.Wait()
+	// a graceful stop for an upgrade can also exit normally, so check for upgrade info first
+	err = cmd.Wait()
+	close(exited)
+	waitForOutput(&scanning, pipeDrainTimeout)
+	// the node exits right after writing upgrade-info.json, so let the watcher have its last look
+	<-watching
+
+	if info, _ := res.AsResult(); info != nil {
+		// wait for the stop to be recorded
+		<-stopped
+		stop := res.Stop()
+		Logger.Printf("daemon stopped for upgrade %q: %s (%s)", info.Name, stop, exitReason(err))
+		return info, nil
+	}
 	if err == nil {
 		return nil, nil
 	}
-	// this will set the error code if it wasn't killed due to upgrade
+	// this will set the error code if it wasn't stopped due to upgrade
 	res.SetError(err)
 	return res.AsResult()
 }
+
+// killForError kills the process we can't watch, and returns err once it is gone
+func killForError(cmd *exec.Cmd, err error) error {
+	_ = cmd.Process.Kill()
+	_ = cmd.Wait()
+	return err
+}
+
+// pipeDrainTimeout is how long to wait for the rest of the output once the process has exited.
+// The pipes only close when every process holding them does, which can include orphaned children.
+const pipeDrainTimeout = time.Second
+
+// waitForOutput waits for the scanners to reach the end of their streams, but no longer than timeout
+func waitForOutput(scanning *sync.WaitGroup, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		scanning.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+// exitReason describes how a process exited for logs
+func exitReason(err error) string {
+	if err == nil {
+		return "exited cleanly"
+	}
+	return err.Error()
+}
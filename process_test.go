@@ -2,7 +2,10 @@ package cosmovisor_test
 
 import (
 	"bytes"
+	"errors"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/suite"
 
@@ -53,6 +56,41 @@ func (s *processTestSuite) TestLaunchProcessLocal() {
 
 	// ended without other upgrade
 	s.Require().Equal(cfg.UpgradeBin("chain2"), currentBin)
+	// and the pid file is gone with the daemon
+	s.Require().NoFileExists(cfg.PIDFile())
+}
+
+// TestLaunchProcessUpgradeInfoFile checks an upgrade is found through upgrade-info.json when the logs don't have it,
+// and that the file doesn't trigger the same upgrade again once it is applied
+func (s *processTestSuite) TestLaunchProcessUpgradeInfoFile() {
+	home := copyTestData(s.T(), "upgradefile")
+	cfg := &cosmovisor.Config{Home: home, Name: "dummyd", PollInterval: 20 * time.Millisecond}
+
+	// without watching the file, the halt is just a failure
+	var stdout, stderr bytes.Buffer
+	doUpgrade, err := cosmovisor.LaunchProcess(cfg, []string{"start"}, &stdout, &stderr)
+	s.Require().Error(err)
+	s.Require().False(doUpgrade)
+	s.Require().Equal(2, cosmovisor.ExitCode(err))
+
+	// the node may be stopped as soon as the file is written, so don't count on the rest of its output
+	s.Require().NoError(os.Remove(cfg.UpgradeInfoFile()))
+	cfg.WatchUpgradeInfo = true
+	doUpgrade, err = cosmovisor.LaunchProcess(cfg, []string{"start"}, &stdout, &stderr)
+	s.Require().NoError(err)
+	s.Require().True(doUpgrade)
+
+	currentBin, err := cfg.CurrentBin()
+	s.Require().NoError(err)
+	s.Require().Equal(cfg.UpgradeBin("chain2"), currentBin)
+
+	// the file still names chain2, which is applied now
+	stdout.Reset()
+	doUpgrade, err = cosmovisor.LaunchProcess(cfg, []string{"start"}, &stdout, &stderr)
+	s.Require().NoError(err)
+	s.Require().False(doUpgrade)
+	s.Require().Equal("Chain 2 is live!\nFinished successfully\n", stdout.String())
+	s.Require().Equal("", stderr.String())
 }
 
 // TestLaunchProcess will try running the script a few times and watch upgrades work properly
@@ -121,3 +159,88 @@ func (s *processTestSuite) TestLaunchProcessWithDownloads() {
 	s.Require().NoError(err)
 	s.Require().Equal(cfg.UpgradeBin("chain3"), currentBin)
 }
+
+// TestLaunchProcessLongLines checks a log line longer than the scanner buffer doesn't stop the output from being read,
+// which would leave the daemon blocked on a full pipe
+func (s *processTestSuite) TestLaunchProcessLongLines() {
+	home := copyTestData(s.T(), "validate")
+	cfg := &cosmovisor.Config{Home: home, Name: "dummyd"}
+	script := `#!/bin/sh
+head -c 100000 /dev/zero | tr '\0' x
+echo
+i=0
+while [ $i -lt 2000 ]; do echo "filling the pipe with line $i"; i=$((i+1)); done
+echo 'UPGRADE "chain2" NEEDED at height: 49: {}'
+echo 'panic: UPGRADE "chain2" NEEDED at height: 49: {}'
+sleep 3
+`
+	s.Require().NoError(os.WriteFile(cfg.GenesisBin(), []byte(script), 0755))
+
+	var stdout, stderr bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		doUpgrade, err := cosmovisor.LaunchProcess(cfg, nil, &stdout, &stderr)
+		if err == nil && !doUpgrade {
+			err = errors.New("no upgrade found")
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		s.Require().NoError(err)
+	case <-time.After(8 * time.Second):
+		s.FailNow("the daemon's output stopped being read")
+	}
+	s.Require().Contains(stdout.String(), "filling the pipe with line 1999\n")
+}
+
+// TestLaunchProcessGracefulStop checks the daemon gets the shutdown signal and grace period
+// before an upgrade, and is killed if it ignores the signal
+func (s *processTestSuite) TestLaunchProcessGracefulStop() {
+	home := copyTestData(s.T(), "graceful")
+	cfg := &cosmovisor.Config{Home: home, Name: "dummyd", ShutdownSignal: "SIGTERM", ShutdownGrace: 5 * time.Second}
+
+	var logs bytes.Buffer
+	defer cosmovisor.Logger.SetOutput(cosmovisor.Logger.Writer())
+	cosmovisor.Logger.SetOutput(&logs)
+
+	// genesis handles TERM, so it gets to clean up and exits well within the grace period
+	var stdout, stderr bytes.Buffer
+	start := time.Now()
+	doUpgrade, err := cosmovisor.LaunchProcess(cfg, []string{"start"}, &stdout, &stderr)
+	s.Require().NoError(err)
+	s.Require().True(doUpgrade)
+	s.Require().Less(int64(time.Since(start)), int64(cfg.ShutdownGrace))
+	s.Require().Equal("Genesis start\nUPGRADE \"chain2\" NEEDED at height: 49: {}\npanic: UPGRADE \"chain2\" NEEDED at height: 49: {}\nFlushed and stopped on TERM\n", stdout.String())
+	s.Require().Contains(logs.String(), "stopping daemon with terminated, waiting up to 5s")
+	s.Require().Contains(logs.String(), `daemon stopped for upgrade "chain2": sent terminated, exited within 5s grace period (exited cleanly)`)
+
+	currentBin, err := cfg.CurrentBin()
+	s.Require().NoError(err)
+	s.Require().Equal(cfg.UpgradeBin("chain2"), currentBin)
+
+	// chain2 ignores TERM, so it gets killed once the grace period is over
+	cfg.ShutdownGrace = time.Second
+	stdout.Reset()
+	logs.Reset()
+	start = time.Now()
+	doUpgrade, err = cosmovisor.LaunchProcess(cfg, []string{"start"}, &stdout, &stderr)
+	s.Require().NoError(err)
+	s.Require().True(doUpgrade)
+	s.Require().GreaterOrEqual(int64(time.Since(start)), int64(cfg.ShutdownGrace))
+	s.Require().NotContains(stdout.String(), "Never should be printed")
+	s.Require().Contains(logs.String(), "daemon did not exit within 1s after terminated, killing it")
+	s.Require().Contains(logs.String(), `daemon stopped for upgrade "chain3": sent terminated, killed after 1s grace period (signal: killed)`)
+
+	currentBin, err = cfg.CurrentBin()
+	s.Require().NoError(err)
+	s.Require().Equal(cfg.UpgradeBin("chain3"), currentBin)
+
+	// and the final upgrade runs
+	stdout.Reset()
+	doUpgrade, err = cosmovisor.LaunchProcess(cfg, nil, &stdout, &stderr)
+	s.Require().NoError(err)
+	s.Require().False(doUpgrade)
+	s.Require().Equal("Chain 3 is live!\n", stdout.String())
+	s.Require().Equal("", stderr.String())
+}
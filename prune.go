@@ -0,0 +1,207 @@
+package cosmovisor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// PruneBackup is the kind of a removed backup dir
+	PruneBackup = "backup"
+	// PruneUpgrade is the kind of a removed upgrade dir
+	PruneUpgrade = "upgrade"
+)
+
+// PruneItem is a backup or upgrade dir that is removed, or would be with a dry run
+type PruneItem struct {
+	Kind   string
+	Name   string
+	Dir    string
+	Size   int64
+	Reason string
+}
+
+// PruneBackups removes the backups beyond the retention limits of cfg, newest are kept first.
+// The newest backup is always kept, whatever its size or age. With dryRun, nothing is removed.
+// The size of a backup is what it takes besides the newer backups and the data dir, which for a hardlink
+// backup is what it copied, so the size limit and the sizes reported are the space actually freed.
+func PruneBackups(cfg *Config, dryRun bool) ([]PruneItem, error) {
+	backups, err := ListBackups(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// a file hardlinked into several backups, or shared with the data dir, only takes space once,
+	// so each backup counts what the newer ones and the data dir don't hold already
+	usage := newDiskUsage()
+	for _, backup := range backups {
+		if backup.Format == BackupHardlink && cfg.DataDir != "" {
+			if _, err := usage.add(cfg.DataDir); err != nil && !os.IsNotExist(err) {
+				return nil, fmt.Errorf("sizing data dir: %w", err)
+			}
+			break
+		}
+	}
+
+	var pruned []PruneItem
+	var total int64
+	// full is set once a backup didn't fit the size limit, so older ones don't either
+	full := false
+	i := 0
+	for _, backup := range backups {
+		// incomplete backups are redone by the next upgrade to their plan, they don't count
+		if !backup.Complete {
+			continue
+		}
+		i++
+		size, err := usage.add(backup.Dir)
+		if err != nil {
+			return pruned, fmt.Errorf("sizing backup %s: %w", backup.Name, err)
+		}
+
+		reason := ""
+		switch {
+		case i == 1:
+		case cfg.BackupKeep > 0 && i > cfg.BackupKeep:
+			reason = fmt.Sprintf("more than %d backups", cfg.BackupKeep)
+		case cfg.BackupMaxAge > 0 && time.Since(backup.Taken) > cfg.BackupMaxAge:
+			reason = fmt.Sprintf("older than %s", cfg.BackupMaxAge)
+		case cfg.BackupMaxSize > 0 && (full || total+size > cfg.BackupMaxSize):
+			reason = fmt.Sprintf("backups would take more than %s", HumanSize(cfg.BackupMaxSize))
+			full = true
+		}
+		if reason == "" {
+			total += size
+			continue
+		}
+
+		item := PruneItem{Kind: PruneBackup, Name: backup.Name, Dir: backup.Dir, Size: size, Reason: reason}
+		if !dryRun {
+			if err := os.RemoveAll(backup.Dir); err != nil {
+				return pruned, fmt.Errorf("removing backup %s: %w", backup.Name, err)
+			}
+		}
+		pruned = append(pruned, item)
+	}
+	return pruned, nil
+}
+
+// PruneUpgrades removes the upgrade dirs the node has been upgraded past since the last rollback, according to the history.
+// The current upgrade, the one a rollback would go back to, and upgrades that were never left
+// (e.g. prepared for an upcoming plan) are kept. With dryRun, nothing is removed.
+func PruneUpgrades(cfg *Config, dryRun bool) ([]PruneItem, error) {
+	current, err := cfg.CurrentUpgradeName()
+	if err != nil {
+		return nil, err
+	}
+	history, err := ReadHistory(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("reading history: %w", err)
+	}
+	upgrades, err := ListUpgrades(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	keep := map[string]bool{current: true}
+	if prev := lastUpgrade(history, func(e HistoryEntry) bool { return e.To == current }); prev != nil {
+		keep[prev.From] = true
+	}
+	// after a rollback, the upgrades it went back from are due again
+	since := history
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Action == HistoryRollback {
+			since = history[i+1:]
+			break
+		}
+	}
+
+	var pruned []PruneItem
+	for _, up := range upgrades {
+		if keep[up.Name] || lastUpgrade(since, func(e HistoryEntry) bool { return e.From == up.Name }) == nil {
+			continue
+		}
+		size, err := dirSize(up.Dir)
+		if err != nil {
+			return pruned, err
+		}
+		item := PruneItem{Kind: PruneUpgrade, Name: up.Name, Dir: up.Dir, Size: size, Reason: "upgraded past"}
+		if !dryRun {
+			if err := os.RemoveAll(up.Dir); err != nil {
+				return pruned, fmt.Errorf("removing upgrade %s: %w", up.Name, err)
+			}
+		}
+		pruned = append(pruned, item)
+	}
+	return pruned, nil
+}
+
+// Prune removes the backups beyond the retention limits and the old upgrade dirs, see PruneBackups and PruneUpgrades
+func Prune(cfg *Config, dryRun bool) ([]PruneItem, error) {
+	backups, err := PruneBackups(cfg, dryRun)
+	if err != nil {
+		return backups, err
+	}
+	upgrades, err := PruneUpgrades(cfg, dryRun)
+	return append(backups, upgrades...), err
+}
+
+// dirSize returns the total size of the files below dir.
+// Hardlinked files are counted in full, even if removing dir wouldn't free them.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// diskUsage adds up the space files take, counting a file with several hardlinks only once
+type diskUsage struct {
+	seen map[fileID]bool
+}
+
+func newDiskUsage() *diskUsage {
+	return &diskUsage{seen: make(map[fileID]bool)}
+}
+
+// add returns the total size of the files below dir that weren't counted before.
+// Where files can't be told apart by inode, every file is counted.
+func (u *diskUsage) add(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if id, ok := fileIDOf(info); ok {
+			if u.seen[id] {
+				return nil
+			}
+			u.seen[id] = true
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+// HumanSize formats a number of bytes for people, e.g. 1.5GiB
+func HumanSize(n int64) string {
+	for _, unit := range sizeUnits[:4] {
+		if n >= unit.n {
+			return fmt.Sprintf("%.1f%s", float64(n)/float64(unit.n), unit.name)
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
@@ -0,0 +1,147 @@
+package cosmovisor_test
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/provenance-io/cosmovisor"
+)
+
+// names returns the names of the pruned items
+func names(items []cosmovisor.PruneItem) []string {
+	var names []string
+	for _, item := range items {
+		names = append(names, item.Name)
+	}
+	return names
+}
+
+func (s *upgradeTestSuite) TestPruneBackups() {
+	home := copyTestData(s.T(), "validate")
+	cfg := &cosmovisor.Config{Home: home, Name: "dummyd", DataDir: filepath.Join(home, "data")}
+
+	// backups taken a day apart, a is the oldest
+	for i, name := range []string{"a", "b", "c", "d"} {
+		s.Require().NoError(cosmovisor.BackupData(cfg, &cosmovisor.UpgradeInfo{Name: name}))
+		taken := time.Now().Add(time.Duration(i-3) * 24 * time.Hour)
+		s.Require().NoError(os.Chtimes(filepath.Join(cfg.BackupDir(name), ".keep"), taken, taken))
+	}
+	backups, err := cosmovisor.ListBackups(cfg)
+	s.Require().NoError(err)
+	s.Require().Len(backups, 4)
+
+	cfg.BackupKeep = 3
+	pruned, err := cosmovisor.PruneBackups(cfg, true)
+	s.Require().NoError(err)
+	s.Require().Equal([]string{"a"}, names(pruned))
+	s.Require().Equal(backups[3].Size, pruned[0].Size)
+	s.Require().Equal(cosmovisor.PruneBackup, pruned[0].Kind)
+	s.Require().DirExists(cfg.BackupDir("a"))
+
+	cfg.BackupKeep = 0
+	cfg.BackupMaxAge = 36 * time.Hour
+	pruned, err = cosmovisor.PruneBackups(cfg, true)
+	s.Require().NoError(err)
+	s.Require().Equal([]string{"b", "a"}, names(pruned))
+
+	cfg.BackupMaxAge = 0
+	// room for d and c
+	cfg.BackupMaxSize = backups[0].Size + backups[1].Size
+	pruned, err = cosmovisor.PruneBackups(cfg, false)
+	s.Require().NoError(err)
+	s.Require().Equal([]string{"b", "a"}, names(pruned))
+	s.Require().NoDirExists(cfg.BackupDir("a"))
+	s.Require().NoDirExists(cfg.BackupDir("b"))
+	s.Require().DirExists(cfg.BackupDir("c"))
+
+	// the newest backup is kept whatever the limits
+	cfg.BackupMaxSize = 1
+	cfg.BackupMaxAge = time.Nanosecond
+	pruned, err = cosmovisor.PruneBackups(cfg, false)
+	s.Require().NoError(err)
+	s.Require().Equal([]string{"c"}, names(pruned))
+	s.Require().DirExists(cfg.BackupDir("d"))
+}
+
+func (s *upgradeTestSuite) TestPruneHardlinkBackups() {
+	home := copyTestData(s.T(), "validate")
+	data := filepath.Join(home, "data")
+	cfg := &cosmovisor.Config{Home: home, Name: "dummyd", DataDir: data, BackupFormat: cosmovisor.BackupHardlink}
+	s.Require().NoError(os.MkdirAll(filepath.Join(data, "state.db"), 0755))
+	s.Require().NoError(os.WriteFile(filepath.Join(data, "state.db", "000001.sst"), make([]byte, 100*1024), 0644))
+
+	for i, name := range []string{"a", "b"} {
+		s.Require().NoError(cosmovisor.BackupData(cfg, &cosmovisor.UpgradeInfo{Name: name}))
+		taken := time.Now().Add(time.Duration(i-1) * time.Hour)
+		s.Require().NoError(os.Chtimes(filepath.Join(cfg.BackupDir(name), ".keep"), taken, taken))
+	}
+	backups, err := cosmovisor.ListBackups(cfg)
+	s.Require().NoError(err)
+	s.Require().Len(backups, 2)
+	s.Require().Greater(backups[1].Size, int64(100*1024))
+
+	// both share the table file with the data dir, so they fit well below its size
+	cfg.BackupMaxSize = 50 * 1024
+	pruned, err := cosmovisor.PruneBackups(cfg, false)
+	s.Require().NoError(err)
+	s.Require().Empty(pruned)
+
+	// and removing one only frees what it copied
+	cfg.BackupMaxSize = 1
+	pruned, err = cosmovisor.PruneBackups(cfg, true)
+	s.Require().NoError(err)
+	s.Require().Equal([]string{"a"}, names(pruned))
+	s.Require().Less(pruned[0].Size, int64(50*1024))
+}
+
+func (s *upgradeTestSuite) TestBackupDataPrunes() {
+	home := copyTestData(s.T(), "validate")
+	cfg := &cosmovisor.Config{Home: home, Name: "dummyd", DataDir: filepath.Join(home, "data"), BackupKeep: 1}
+
+	s.Require().NoError(cosmovisor.DoUpgrade(cfg, &cosmovisor.UpgradeInfo{Name: "chain2"}))
+	old := time.Now().Add(-time.Hour)
+	s.Require().NoError(os.Chtimes(filepath.Join(cfg.BackupDir("chain2"), ".keep"), old, old))
+	s.Require().NoError(cosmovisor.DoUpgrade(cfg, &cosmovisor.UpgradeInfo{Name: "chain3"}))
+	s.Require().NoDirExists(cfg.BackupDir("chain2"))
+	s.Require().DirExists(cfg.BackupDir("chain3"))
+}
+
+func (s *upgradeTestSuite) TestPruneUpgrades() {
+	home := copyTestData(s.T(), "validate")
+	cfg := &cosmovisor.Config{Home: home, Name: "dummyd"}
+	s.Require().NoError(cosmovisor.AddUpgrade(cfg, "chain4", cfg.UpgradeBin("chain3"), false))
+	s.Require().NoError(cosmovisor.AddUpgrade(cfg, "chain5", cfg.UpgradeBin("chain3"), false))
+
+	// nothing is known to be upgraded past without history
+	pruned, err := cosmovisor.PruneUpgrades(cfg, false)
+	s.Require().NoError(err)
+	s.Require().Empty(pruned)
+
+	for _, name := range []string{"chain2", "chain3", "chain4"} {
+		s.Require().NoError(cosmovisor.DoUpgrade(cfg, &cosmovisor.UpgradeInfo{Name: name}))
+	}
+
+	// chain3 is the rollback target, and chain5 is still to come
+	pruned, err = cosmovisor.PruneUpgrades(cfg, true)
+	s.Require().NoError(err)
+	s.Require().Equal([]string{"chain2"}, names(pruned))
+	s.Require().Equal(cosmovisor.PruneUpgrade, pruned[0].Kind)
+	s.Require().DirExists(cfg.UpgradeDir("chain2"))
+
+	// after a rollback, the upgrades rolled back from are due again
+	_, err = cosmovisor.Rollback(cfg, "chain2", false, false)
+	s.Require().NoError(err)
+	pruned, err = cosmovisor.PruneUpgrades(cfg, false)
+	s.Require().NoError(err)
+	s.Require().Empty(pruned)
+
+	s.Require().NoError(cosmovisor.DoUpgrade(cfg, &cosmovisor.UpgradeInfo{Name: "chain3"}))
+	s.Require().NoError(cosmovisor.DoUpgrade(cfg, &cosmovisor.UpgradeInfo{Name: "chain4"}))
+	pruned, err = cosmovisor.Prune(cfg, false)
+	s.Require().NoError(err)
+	s.Require().Equal([]string{"chain2"}, names(pruned))
+	s.Require().NoDirExists(cfg.UpgradeDir("chain2"))
+	s.Require().DirExists(cfg.UpgradeDir("chain3"))
+	s.Require().DirExists(cfg.UpgradeDir("chain5"))
+}
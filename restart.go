@@ -0,0 +1,103 @@
+package cosmovisor
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// RestartPolicy controls whether the daemon is relaunched after it exits on its own
+type RestartPolicy string
+
+const (
+	// RestartNever leaves restarting to whatever runs cosmovisor (the default)
+	RestartNever RestartPolicy = "never"
+	// RestartOnFailure relaunches the daemon when it exits with an error or is killed by a signal
+	RestartOnFailure RestartPolicy = "on-failure"
+	// RestartAlways relaunches the daemon whenever it exits, even cleanly
+	RestartAlways RestartPolicy = "always"
+)
+
+// ErrCrashLoop is returned when the daemon needed more restarts within the restart window than allowed
+var ErrCrashLoop = errors.New("crash loop")
+
+// Restarter applies the restart policy of a config, keeping track of recent restarts
+// to compute the backoff delay and to detect crash loops.
+type Restarter struct {
+	cfg *Config
+	// restarts holds the times of the restarts within the restart window
+	restarts []time.Time
+	delay    time.Duration
+}
+
+// NewRestarter returns a Restarter for the policy in cfg
+func NewRestarter(cfg *Config) *Restarter {
+	return &Restarter{cfg: cfg, delay: cfg.RestartDelay}
+}
+
+// Next decides what to do after the daemon exited with exitErr (nil for a clean exit) at the given time.
+// It returns the delay to wait before restarting, and whether to restart at all.
+// Errors that did not come from the daemon itself never cause a restart.
+// If the restart would exceed the allowed restarts within the window, an error wrapping ErrCrashLoop is returned.
+func (r *Restarter) Next(exitErr error, now time.Time) (time.Duration, bool, error) {
+	switch r.cfg.RestartPolicy {
+	case RestartAlways:
+		if exitErr != nil && !isDaemonExit(exitErr) {
+			return 0, false, nil
+		}
+	case RestartOnFailure:
+		if exitErr == nil || !isDaemonExit(exitErr) {
+			return 0, false, nil
+		}
+	default:
+		return 0, false, nil
+	}
+
+	// forget restarts that fell out of the window, and reset the backoff if the daemon was stable since
+	recent := r.restarts[:0]
+	for _, t := range r.restarts {
+		if now.Sub(t) < r.cfg.RestartWindow {
+			recent = append(recent, t)
+		}
+	}
+	r.restarts = recent
+	if len(r.restarts) == 0 {
+		r.delay = r.cfg.RestartDelay
+	}
+
+	if r.cfg.RestartMaxRestarts > 0 && len(r.restarts) >= r.cfg.RestartMaxRestarts {
+		return 0, false, WithExitCode(ExitCodeCrashLoop, fmt.Errorf("%w: daemon exited %d times within %s, giving up: %v",
+			ErrCrashLoop, len(r.restarts)+1, r.cfg.RestartWindow, exitErr))
+	}
+
+	delay := r.delay
+	r.restarts = append(r.restarts, now)
+	r.delay = r.nextDelay(delay)
+	return delay, true, nil
+}
+
+// Reset clears the restart history and backoff, eg. after a successful upgrade
+func (r *Restarter) Reset() {
+	r.restarts = nil
+	r.delay = r.cfg.RestartDelay
+}
+
+// nextDelay applies the backoff multiplier to delay, capped at the max delay
+func (r *Restarter) nextDelay(delay time.Duration) time.Duration {
+	backoff := r.cfg.RestartBackoff
+	if backoff < 1 {
+		backoff = 1
+	}
+	next := time.Duration(float64(delay) * backoff)
+	if r.cfg.RestartMaxDelay > 0 && next > r.cfg.RestartMaxDelay {
+		next = r.cfg.RestartMaxDelay
+	}
+	return next
+}
+
+// isDaemonExit returns true if err comes from the daemon exiting, rather than from cosmovisor itself
+func isDaemonExit(err error) bool {
+	var exitErr *exec.ExitError
+	return errors.As(err, &exitErr)
+}
@@ -0,0 +1,97 @@
+package cosmovisor_test
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/provenance-io/cosmovisor"
+)
+
+// daemonExitError runs a command that exits with the given code to get a real *exec.ExitError
+func daemonExitError(t *testing.T, code string) error {
+	t.Helper()
+	err := exec.Command("sh", "-c", "exit "+code).Run()
+	require.Error(t, err)
+	return err
+}
+
+func TestRestarterPolicies(t *testing.T) {
+	crash := daemonExitError(t, "3")
+	internal := errors.New("cannot download binary")
+
+	cases := map[string]struct {
+		policy        cosmovisor.RestartPolicy
+		err           error
+		expectRestart bool
+	}{
+		"never on crash":             {policy: cosmovisor.RestartNever, err: crash},
+		"never on clean exit":        {policy: cosmovisor.RestartNever},
+		"unset on crash":             {err: crash},
+		"on-failure on crash":        {policy: cosmovisor.RestartOnFailure, err: crash, expectRestart: true},
+		"on-failure on clean exit":   {policy: cosmovisor.RestartOnFailure},
+		"on-failure on own error":    {policy: cosmovisor.RestartOnFailure, err: internal},
+		"always on crash":            {policy: cosmovisor.RestartAlways, err: crash, expectRestart: true},
+		"always on clean exit":       {policy: cosmovisor.RestartAlways, expectRestart: true},
+		"always on own error":        {policy: cosmovisor.RestartAlways, err: internal},
+		"on-failure on wrapped exit": {policy: cosmovisor.RestartOnFailure, err: fmt.Errorf("daemon: %w", crash), expectRestart: true},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			cfg := &cosmovisor.Config{RestartPolicy: tc.policy, RestartDelay: time.Second, RestartWindow: time.Minute, RestartMaxRestarts: 3}
+			delay, restart, err := cosmovisor.NewRestarter(cfg).Next(tc.err, time.Now())
+			require.NoError(t, err)
+			require.Equal(t, tc.expectRestart, restart)
+			if restart {
+				require.Equal(t, time.Second, delay)
+			}
+		})
+	}
+}
+
+func TestRestarterBackoffAndCrashLoop(t *testing.T) {
+	crash := daemonExitError(t, "1")
+	cfg := &cosmovisor.Config{
+		RestartPolicy:      cosmovisor.RestartOnFailure,
+		RestartDelay:       time.Second,
+		RestartBackoff:     3,
+		RestartMaxDelay:    5 * time.Second,
+		RestartMaxRestarts: 4,
+		RestartWindow:      time.Minute,
+	}
+	r := cosmovisor.NewRestarter(cfg)
+	now := time.Now()
+
+	// delays grow by the backoff until capped
+	for i, expected := range []time.Duration{time.Second, 3 * time.Second, 5 * time.Second, 5 * time.Second} {
+		delay, restart, err := r.Next(crash, now.Add(time.Duration(i)*time.Second))
+		require.NoError(t, err, i)
+		require.True(t, restart, i)
+		require.Equal(t, expected, delay, i)
+	}
+
+	// one more within the window is a crash loop
+	_, restart, err := r.Next(crash, now.Add(10*time.Second))
+	require.ErrorIs(t, err, cosmovisor.ErrCrashLoop)
+	require.Contains(t, err.Error(), "5 times within 1m0s")
+	require.False(t, restart)
+
+	// once the window has passed, the backoff starts over
+	delay, restart, err := r.Next(crash, now.Add(2*time.Minute))
+	require.NoError(t, err)
+	require.True(t, restart)
+	require.Equal(t, time.Second, delay)
+
+	// and so it does after a reset
+	_, _, err = r.Next(crash, now.Add(2*time.Minute))
+	require.NoError(t, err)
+	r.Reset()
+	delay, _, err = r.Next(crash, now.Add(2*time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, time.Second, delay)
+}
@@ -0,0 +1,91 @@
+package cosmovisor
+
+import "fmt"
+
+// RollbackResult describes what Rollback did
+type RollbackResult struct {
+	From string
+	To   string
+	// Backup is the upgrade whose data backup was restored, empty if the data was left alone
+	Backup string
+	// MovedData is where the replaced data dir was moved to
+	MovedData string
+}
+
+// Rollback points the current link back at an earlier upgrade, or genesis.
+// With to empty, it goes back to the upgrade the history says the current one was reached from.
+// With restoreData, the data dir is moved aside and replaced by the backup taken when the target was upgraded away from,
+// so nothing is deleted. Without a history entry for that, the backup taken before the current upgrade is used.
+// The link is switched before the data is restored, and switched back if the restore fails.
+// It refuses to run while the pid file says the daemon is running,
+// and unless force is set, to restore a priv_validator_state.json behind the current one.
+func Rollback(cfg *Config, to string, restoreData, force bool) (*RollbackResult, error) {
+	if err := checkNotRunning(cfg); err != nil {
+		return nil, err
+	}
+	from, err := cfg.CurrentUpgradeName()
+	if err != nil {
+		return nil, err
+	}
+	history, err := ReadHistory(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("reading history: %w", err)
+	}
+
+	if to == "" {
+		prev := lastUpgrade(history, func(e HistoryEntry) bool { return e.To == from })
+		if prev == nil {
+			return nil, fmt.Errorf("no upgrade to %s in the history, name the upgrade to roll back to", from)
+		}
+		to = prev.From
+	}
+	if to == from {
+		return nil, fmt.Errorf("%s is the current upgrade already", to)
+	}
+
+	bin := cfg.GenesisBin()
+	if to != genesisDir {
+		bin = cfg.UpgradeBin(to)
+	}
+	if err := EnsureBinary(bin); err != nil {
+		return nil, WithExitCode(ExitCodeBinary, fmt.Errorf("cannot roll back to %s: %w", to, err))
+	}
+
+	// the link is switched first, a failed restore is undone by RestoreBackup and then the link is switched back,
+	// so a failure never leaves the data of one upgrade with the binary of another
+	if err := cfg.setCurrent(to); err != nil {
+		return nil, WithExitCode(ExitCodeSymlink, err)
+	}
+	result := &RollbackResult{From: from, To: to}
+	if restoreData {
+		result.Backup = from
+		if left := lastUpgrade(history, func(e HistoryEntry) bool { return e.From == to }); left != nil {
+			result.Backup = left.To
+		}
+		if result.MovedData, err = RestoreBackup(cfg, result.Backup, force); err != nil {
+			if linkErr := cfg.setCurrent(from); linkErr != nil {
+				return nil, WithExitCode(ExitCodeSymlink, fmt.Errorf("%v, and pointing current back at %s failed: %w", err, from, linkErr))
+			}
+			return nil, err
+		}
+	}
+
+	if err := AppendHistory(cfg, HistoryEntry{
+		Action:    HistoryRollback,
+		From:      from,
+		To:        to,
+		Backup:    result.Backup,
+		MovedData: result.MovedData,
+	}); err != nil {
+		return result, fmt.Errorf("rolled back, but recording it in the history failed: %w", err)
+	}
+	return result, nil
+}
+
+// setCurrent points the current link at the named upgrade, or genesis
+func (cfg *Config) setCurrent(name string) error {
+	if name == genesisDir {
+		return cfg.SetCurrentGenesis()
+	}
+	return cfg.SetCurrentUpgrade(name)
+}
@@ -0,0 +1,130 @@
+package cosmovisor_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/provenance-io/cosmovisor"
+)
+
+// writeState replaces the content of the state file in the data dir
+func (s *upgradeTestSuite) writeState(cfg *cosmovisor.Config, state string) {
+	s.Require().NoError(os.WriteFile(filepath.Join(cfg.DataDir, "application.db"), []byte(state), 0644))
+}
+
+func (s *upgradeTestSuite) requireState(dir, state string) {
+	bz, err := os.ReadFile(filepath.Join(dir, "application.db"))
+	s.Require().NoError(err)
+	s.Require().Equal(state, string(bz))
+}
+
+func (s *upgradeTestSuite) TestRollback() {
+	home := copyTestData(s.T(), "validate")
+	cfg := &cosmovisor.Config{Home: home, Name: "dummyd", DataDir: filepath.Join(home, "data")}
+
+	s.writeState(cfg, "genesis state")
+	s.Require().NoError(cosmovisor.DoUpgrade(cfg, &cosmovisor.UpgradeInfo{Name: "chain2", Height: 50}))
+	s.writeState(cfg, "broken by chain2")
+
+	result, err := cosmovisor.Rollback(cfg, "", true, false)
+	s.Require().NoError(err)
+	s.Require().Equal("chain2", result.From)
+	s.Require().Equal("genesis", result.To)
+	s.Require().Equal("chain2", result.Backup)
+	s.assertCurrentLink(*cfg, "genesis")
+
+	// the data is back as it was before the upgrade, and the broken data is kept aside
+	s.requireState(cfg.DataDir, "genesis state")
+	s.requireState(result.MovedData, "broken by chain2")
+	s.Require().FileExists(filepath.Join(result.MovedData, "modulesDir", "state.db"))
+	s.Require().FileExists(filepath.Join(cfg.DataDir, "modulesDir", "state.db"))
+
+	history, err := cosmovisor.ReadHistory(cfg)
+	s.Require().NoError(err)
+	s.Require().Len(history, 2)
+	s.Require().Equal(cosmovisor.HistoryUpgrade, history[0].Action)
+	s.Require().Equal("genesis", history[0].From)
+	s.Require().Equal(int64(50), history[0].Height)
+	s.Require().Equal(cosmovisor.HistoryRollback, history[1].Action)
+	s.Require().Equal(result.MovedData, history[1].MovedData)
+
+	// genesis was never upgraded to
+	_, err = cosmovisor.Rollback(cfg, "", false, false)
+	s.Require().Error(err)
+}
+
+func (s *upgradeTestSuite) TestRollbackSeveralUpgrades() {
+	home := copyTestData(s.T(), "validate")
+	cfg := &cosmovisor.Config{Home: home, Name: "dummyd", DataDir: filepath.Join(home, "data")}
+
+	s.writeState(cfg, "genesis state")
+	s.Require().NoError(cosmovisor.DoUpgrade(cfg, &cosmovisor.UpgradeInfo{Name: "chain2"}))
+	s.writeState(cfg, "chain2 state")
+	s.Require().NoError(cosmovisor.DoUpgrade(cfg, &cosmovisor.UpgradeInfo{Name: "chain3"}))
+	s.writeState(cfg, "chain3 state")
+
+	// without restoring, only the link changes
+	result, err := cosmovisor.Rollback(cfg, "", false, false)
+	s.Require().NoError(err)
+	s.Require().Equal("chain2", result.To)
+	s.Require().Empty(result.Backup)
+	s.assertCurrentLink(*cfg, filepath.Join("upgrades", "chain2"))
+	s.requireState(cfg.DataDir, "chain3 state")
+
+	// back to genesis, restoring the backup taken when genesis was left
+	result, err = cosmovisor.Rollback(cfg, "genesis", true, false)
+	s.Require().NoError(err)
+	s.Require().Equal("chain2", result.Backup)
+	s.assertCurrentLink(*cfg, "genesis")
+	s.requireState(cfg.DataDir, "genesis state")
+}
+
+func (s *upgradeTestSuite) TestRollbackRefuses() {
+	home := copyTestData(s.T(), "validate")
+	cfg := &cosmovisor.Config{Home: home, Name: "dummyd", DataDir: filepath.Join(home, "data")}
+	s.Require().NoError(cfg.SetCurrentUpgrade("chain3"))
+
+	// no history of how chain3 was reached
+	_, err := cosmovisor.Rollback(cfg, "", false, false)
+	s.Require().Error(err)
+	// no valid binary to roll back to
+	_, err = cosmovisor.Rollback(cfg, "noexec", false, false)
+	s.Require().Error(err)
+	_, err = cosmovisor.Rollback(cfg, "chain3", false, false)
+	s.Require().Error(err)
+	// no backup to restore, nothing is changed
+	_, err = cosmovisor.Rollback(cfg, "chain2", true, false)
+	s.Require().Error(err)
+	s.Require().Contains(err.Error(), "no complete backup")
+	s.assertCurrentLink(*cfg, filepath.Join("upgrades", "chain3"))
+	s.requireState(cfg.DataDir, "test\n")
+
+	// without history, the backup taken before the current upgrade is used
+	s.Require().NoError(cosmovisor.BackupData(cfg, &cosmovisor.UpgradeInfo{Name: "chain3"}))
+	s.writeState(cfg, "broken by chain3")
+	result, err := cosmovisor.Rollback(cfg, "chain2", true, false)
+	s.Require().NoError(err)
+	s.Require().Equal("chain3", result.Backup)
+	s.requireState(cfg.DataDir, "test\n")
+	s.assertCurrentLink(*cfg, filepath.Join("upgrades", "chain2"))
+}
+
+func (s *upgradeTestSuite) TestRollbackUndoneOnFailedRestore() {
+	home := copyTestData(s.T(), "validate")
+	cfg := &cosmovisor.Config{Home: home, Name: "dummyd", DataDir: filepath.Join(home, "data")}
+	state := filepath.Join(cfg.DataDir, "priv_validator_state.json")
+	s.Require().NoError(os.WriteFile(state, []byte(`{"height": "100", "round": 0, "step": 3}`), 0600))
+	s.Require().NoError(cosmovisor.DoUpgrade(cfg, &cosmovisor.UpgradeInfo{Name: "chain2", Height: 100}))
+
+	// the validator signed after the backup, so the restore is refused and the link is put back
+	s.Require().NoError(os.WriteFile(state, []byte(`{"height": "101", "round": 0, "step": 3}`), 0600))
+	s.writeState(cfg, "chain2 state")
+	_, err := cosmovisor.Rollback(cfg, "", true, false)
+	s.Require().ErrorIs(err, cosmovisor.ErrValidatorStateBackwards)
+	s.assertCurrentLink(*cfg, filepath.Join("upgrades", "chain2"))
+	s.requireState(cfg.DataDir, "chain2 state")
+
+	history, err := cosmovisor.ReadHistory(cfg)
+	s.Require().NoError(err)
+	s.Require().Len(history, 1)
+}
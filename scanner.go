@@ -2,8 +2,14 @@ package cosmovisor
 
 import (
 	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Trim off whitespace around the info - match least greedy, grab as much space on both sides
@@ -16,22 +22,114 @@ import (
 //    }
 //    return fmt.Sprintf("height: %d", p.Height)
 
-// Accommodate both json and plain logging formats (json: \"plan\", plain: "plan").
-var plainUpgradeRegex = regexp.MustCompile(`UPGRADE "(.*?)" NEEDED at height: (\d+):\s+(\S*)`)
-var jsonUpgradeRegex = regexp.MustCompile(`UPGRADE (?:\\|)"(.*?)(?:\\|)" NEEDED at height: (\d+):\s+(.*)$`)
+// dueAtPattern matches either form of DueAt, capturing the height or the RFC3339 time.
+const dueAtPattern = `(?:height: (\d+)|time: (\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:\d{2})))`
+
+// upgradeRegex matches the message up to the info, which is whatever follows it.
+// For json logs it is matched against the decoded message, so the quotes are never escaped.
+// Submatches are: name, height, time.
+var upgradeRegex = regexp.MustCompile(`UPGRADE "(.*?)" NEEDED at ` + dueAtPattern + `:`)
+
+// logfmtFieldRegex matches the last key=value field the logger appended after the message on a plain log line
+var logfmtFieldRegex = regexp.MustCompile(`\s+[A-Za-z_][\w.\-]*=(?:"(?:[^"\\]|\\.)*"|[^\s"]*)$`)
+
+// ansiRegex matches the color escapes of console loggers
+var ansiRegex = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// maxInfoLines is how many lines a pretty printed json info may span before we give up on it
+const maxInfoLines = 1000
 
 // UpgradeInfo is the details from the regexp
 type UpgradeInfo struct {
+	// Time is when a time based plan was due, it is zero for height based plans
+	Time time.Time
 	Name string
 	Info string
+	// Height is the height a height based plan was due at, it is zero for time based plans
+	Height int64
 }
 
-type scannerState int
+// DueAt describes when the upgrade was due, the same way the SDK does in its log message
+func (u *UpgradeInfo) DueAt() string {
+	if !u.Time.IsZero() {
+		return fmt.Sprintf("time: %s", u.Time.UTC().Format(time.RFC3339))
+	}
+	return fmt.Sprintf("height: %d", u.Height)
+}
 
-const (
-	scannerStateInitial scannerState = iota
-	scannerStatePending
-)
+// newUpgradeInfo builds the UpgradeInfo from the submatches of upgradeRegex
+func newUpgradeInfo(subs []string, infoText string) (*UpgradeInfo, error) {
+	info := &UpgradeInfo{Name: subs[1], Info: infoText}
+	if subs[2] != "" {
+		height, err := strconv.ParseInt(subs[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing upgrade height: %w", err)
+		}
+		info.Height = height
+	} else {
+		t, err := time.Parse(time.RFC3339Nano, subs[3])
+		if err != nil {
+			return nil, fmt.Errorf("parsing upgrade time: %w", err)
+		}
+		info.Time = t
+	}
+	return info, nil
+}
+
+// parseUpgradeMessage finds the upgrade message in msg, returning nil if there is none.
+// The info is everything after the message. On plain log lines the fields the logger appended are dropped,
+// unless the info is json, which is taken up to where the json value ends.
+// If the info is json that continues on the next lines (pretty printed), partial holds what we have so far.
+func parseUpgradeMessage(msg string, plain bool) (info *UpgradeInfo, partial string, err error) {
+	loc := upgradeRegex.FindStringSubmatchIndex(msg)
+	if loc == nil {
+		return nil, "", nil
+	}
+	subs := make([]string, 4)
+	for i := range subs {
+		if loc[2*i] >= 0 {
+			subs[i] = msg[loc[2*i]:loc[2*i+1]]
+		}
+	}
+	rest := strings.TrimSpace(msg[loc[1]:])
+
+	value, incomplete := jsonPrefix(rest)
+	switch {
+	case value != "":
+		rest = value
+	case incomplete:
+		partial = rest
+	case plain:
+		rest = trimLogFields(rest)
+	}
+	info, err = newUpgradeInfo(subs, rest)
+	return info, partial, err
+}
+
+// jsonPrefix returns the json object or array s starts with.
+// If s starts one that doesn't end within s, incomplete is true.
+func jsonPrefix(s string) (value string, incomplete bool) {
+	if !strings.HasPrefix(s, "{") && !strings.HasPrefix(s, "[") {
+		return "", false
+	}
+	dec := json.NewDecoder(strings.NewReader(s))
+	var raw json.RawMessage
+	if err := dec.Decode(&raw); err != nil {
+		return "", errors.Is(err, io.ErrUnexpectedEOF)
+	}
+	return s[:dec.InputOffset()], false
+}
+
+// trimLogFields drops the key=value fields a plain logger appends after the message
+func trimLogFields(s string) string {
+	for {
+		loc := logfmtFieldRegex.FindStringIndex(s)
+		if loc == nil {
+			return strings.TrimSpace(s)
+		}
+		s = s[:loc[0]]
+	}
+}
 
 const (
 	upgradeText       = "UPGRADE "
@@ -40,58 +138,35 @@ const (
 	panicText         = "panic: UPGRADE"
 )
 
-// WaitForUpdate will listen to the scanner until a line matches upgradeRegexp.
+// WaitForUpdate will listen to the scanner until the default detectors find an upgrade.
 // It returns (info, nil) on a matching line
 // It returns (nil, err) if the input stream errored
 // It returns (nil, nil) if the input closed without ever matching the regexp
 func WaitForUpdate(scanner *bufio.Scanner) (*UpgradeInfo, error) {
-	state := scannerStateInitial
+	return DetectUpgrade(scanner, DefaultDetector())
+}
+
+// ScanLines is bufio.ScanLines, except that a line too long for the scanner's buffer is passed on in pieces
+// rather than failing the scanner, which would stop it reading the daemon's output
+func ScanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	advance, token, err = bufio.ScanLines(data, atEOF)
+	if advance == 0 && token == nil && err == nil && len(data) >= bufio.MaxScanTokenSize {
+		return len(data), data, nil
+	}
+	return advance, token, err
+}
 
-	var info *UpgradeInfo
+// DetectUpgrade feeds every line of the scanner to the detector until it finds an upgrade.
+// The return values are the same as for WaitForUpdate.
+func DetectUpgrade(scanner *bufio.Scanner, detector Detector) (*UpgradeInfo, error) {
 	for scanner.Scan() {
 		line := scanner.Text()
-
-		switch state {
-		case scannerStateInitial:
-			// Don't use the regexp unless we are actually looking at an upgrade line.
-			// Compiled regex matching is about 20x more expensive than strings.Contains(). (10 vs 200).
-			if !(strings.Contains(line, upgradeText) && strings.Contains(line, neededText)) {
-				continue
-			}
-			// Parse the info, and kick into holding for panic or consensus failure message.
-			// Hacky: If starts with { and ends with }, parse into json object.
-			if jsonLog, ok := isJSONLog(line); ok {
-				if !jsonUpgradeRegex.MatchString(jsonLog) {
-					continue
-				}
-
-				jsonLine, err := parseJSONLog(jsonLog)
-				if err != nil {
-					return nil, err
-				}
-
-				subs := jsonUpgradeRegex.FindStringSubmatch(jsonLine.Message)
-				info = &UpgradeInfo{
-					Name: subs[1],
-					Info: subs[3],
-				}
-				state = scannerStatePending
-				continue
-			} else {
-				subs := plainUpgradeRegex.FindStringSubmatch(line)
-				info = &UpgradeInfo{
-					Name: subs[1],
-					Info: subs[3],
-				}
-				state = scannerStatePending
-				continue
-			}
-		case scannerStatePending:
-			// We have hit the panic or consensus failure after an upgrade log message, return out and update.
-			if strings.Contains(line, panicText) || strings.Contains(line, consensusFailText) {
-				return info, nil
-			}
-			continue
+		if strings.Contains(line, "\x1b[") {
+			line = ansiRegex.ReplaceAllString(line, "")
+		}
+		info, err := detector.Detect(line)
+		if err != nil || info != nil {
+			return info, err
 		}
 	}
 	return nil, scanner.Err()
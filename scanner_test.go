@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"io"
 	"testing"
+	"time"
 
 	"github.com/provenance-io/cosmovisor"
 
@@ -49,8 +50,9 @@ func TestWaitForInfo(t *testing.T) {
 				`err="UPGRADE \"myname\" NEEDED at height: 123: " module=consensus message="CONSENSUS FAILURE!!!"` + "\n",
 			},
 			expectUpgrade: &cosmovisor.UpgradeInfo{
-				Name: "myname",
-				Info: "",
+				Name:   "myname",
+				Height: 123,
+				Info:   "",
 			},
 		},
 		"match consensus failure with info": {
@@ -60,8 +62,9 @@ func TestWaitForInfo(t *testing.T) {
 				`"err="UPGRADE \"test\" NEEDED at height: 10: /app/plan.json" another=thing module=consensus stack="goroutine 91 [running]:\nruntime/debug.Stack(0xc001709a98, 0x1c3cb40, 0xc001df3620)\n\truntime/debug/stack.go:24 +0x9f\ngithub.com/tendermint/tendermint/consensus.(*State).receiveRoutine.func2(0xc001250000, 0x21b4ba0)\n\tgithub.com/tendermint/tendermint@v0.34.8/consensus/state.go:726" message="CONSENSUS FAILURE!!!"` + "\n",
 			},
 			expectUpgrade: &cosmovisor.UpgradeInfo{
-				Name: "test",
-				Info: "/app/plan.json",
+				Name:   "test",
+				Height: 10,
+				Info:   "/app/plan.json",
 			},
 		},
 		"match consensus failure json with no info": {
@@ -70,8 +73,9 @@ func TestWaitForInfo(t *testing.T) {
 				`{"level":"error","module":"consensus","err":"UPGRADE \"jsontest\" NEEDED at height: 10: ","message":"CONSENSUS FAILURE!!!"}` + "\n",
 			},
 			expectUpgrade: &cosmovisor.UpgradeInfo{
-				Name: "jsontest",
-				Info: "",
+				Name:   "jsontest",
+				Height: 10,
+				Info:   "",
 			},
 		},
 		"match consensus failure json with info": {
@@ -80,8 +84,9 @@ func TestWaitForInfo(t *testing.T) {
 				`{"level":"error","module":"consensus","err":"UPGRADE \"jsontest\" NEEDED at height: 10: /app/plan.json","message":"CONSENSUS FAILURE!!!"}` + "\n",
 			},
 			expectUpgrade: &cosmovisor.UpgradeInfo{
-				Name: "jsontest",
-				Info: "/app/plan.json",
+				Name:   "jsontest",
+				Height: 10,
+				Info:   "/app/plan.json",
 			},
 		},
 		"panic text with no info": {
@@ -91,8 +96,9 @@ func TestWaitForInfo(t *testing.T) {
 				`panic: UPGRADE "test-panic" NEEDED at height: 10: ` + "\n",
 			},
 			expectUpgrade: &cosmovisor.UpgradeInfo{
-				Name: "test-panic",
-				Info: "",
+				Name:   "test-panic",
+				Height: 10,
+				Info:   "",
 			},
 		},
 		"panic text with info": {
@@ -102,8 +108,9 @@ func TestWaitForInfo(t *testing.T) {
 				`panic: UPGRADE "test-panic" NEEDED at height: 10: /app/plan.json` + "\n",
 			},
 			expectUpgrade: &cosmovisor.UpgradeInfo{
-				Name: "test-panic",
-				Info: "/app/plan.json",
+				Name:   "test-panic",
+				Height: 10,
+				Info:   "/app/plan.json",
 			},
 		},
 		"panic text with info as json": {
@@ -112,8 +119,9 @@ func TestWaitForInfo(t *testing.T) {
 				`panic: UPGRADE "chain2" NEEDED at height: 49: {"binaries":{"linux/amd64":"https://github.com/cosmos/cosmos-sdk/raw/51249cb93130810033408934454841c98423ed4b/cosmovisor/testdata/repo/zip_binary/autod.zip?checksum=sha256:dc48829b4126ae95bc0db316c66d4e9da5f3db95e212665b6080638cca77e998"}}` + "\n",
 			},
 			expectUpgrade: &cosmovisor.UpgradeInfo{
-				Name: "chain2",
-				Info: `{"binaries":{"linux/amd64":"https://github.com/cosmos/cosmos-sdk/raw/51249cb93130810033408934454841c98423ed4b/cosmovisor/testdata/repo/zip_binary/autod.zip?checksum=sha256:dc48829b4126ae95bc0db316c66d4e9da5f3db95e212665b6080638cca77e998"}}`,
+				Name:   "chain2",
+				Height: 49,
+				Info:   `{"binaries":{"linux/amd64":"https://github.com/cosmos/cosmos-sdk/raw/51249cb93130810033408934454841c98423ed4b/cosmovisor/testdata/repo/zip_binary/autod.zip?checksum=sha256:dc48829b4126ae95bc0db316c66d4e9da5f3db95e212665b6080638cca77e998"}}`,
 			},
 		},
 		"consensus failure with info as json": {
@@ -122,8 +130,9 @@ func TestWaitForInfo(t *testing.T) {
 				`message="CONSENSUS FAILURE!!!" err="UPGRADE \"chain2\" NEEDED at height: 49: {\"binaries\":{\"linux/amd64\":\"https://github.com/cosmos/cosmos-sdk/raw/51249cb93130810033408934454841c98423ed4b/cosmovisor/testdata/repo/zip_binary/autod.zip?checksum=sha256:dc48829b4126ae95bc0db316c66d4e9da5f3db95e212665b6080638cca77e998\"}}"` + "\n",
 			},
 			expectUpgrade: &cosmovisor.UpgradeInfo{
-				Name: "chain2",
-				Info: `{"binaries":{"linux/amd64":"https://github.com/cosmos/cosmos-sdk/raw/51249cb93130810033408934454841c98423ed4b/cosmovisor/testdata/repo/zip_binary/autod.zip?checksum=sha256:dc48829b4126ae95bc0db316c66d4e9da5f3db95e212665b6080638cca77e998"}}`,
+				Name:   "chain2",
+				Height: 49,
+				Info:   `{"binaries":{"linux/amd64":"https://github.com/cosmos/cosmos-sdk/raw/51249cb93130810033408934454841c98423ed4b/cosmovisor/testdata/repo/zip_binary/autod.zip?checksum=sha256:dc48829b4126ae95bc0db316c66d4e9da5f3db95e212665b6080638cca77e998"}}`,
 			},
 		},
 		"panic text with info as https": {
@@ -132,8 +141,9 @@ func TestWaitForInfo(t *testing.T) {
 				`panic: UPGRADE "chain2" NEEDED at height: 49: https://really.cool.network/downloads/v0/download.zip?sha256:dc48829b4126ae95bc0db316c66d4e9da5f3db95e212665b6080638cca77e998` + "\n",
 			},
 			expectUpgrade: &cosmovisor.UpgradeInfo{
-				Name: "chain2",
-				Info: `https://really.cool.network/downloads/v0/download.zip?sha256:dc48829b4126ae95bc0db316c66d4e9da5f3db95e212665b6080638cca77e998`,
+				Name:   "chain2",
+				Height: 49,
+				Info:   `https://really.cool.network/downloads/v0/download.zip?sha256:dc48829b4126ae95bc0db316c66d4e9da5f3db95e212665b6080638cca77e998`,
 			},
 		},
 		"consensus failure with info as https": {
@@ -142,8 +152,9 @@ func TestWaitForInfo(t *testing.T) {
 				`message="CONSENSUS FAILURE!!!" err="UPGRADE \"chain2\" NEEDED at height: 49: https://really.cool.network/downloads/v0/download.zip?sha256:dc48829b4126ae95bc0db316c66d4e9da5f3db95e212665b6080638cca77e998"` + "\n",
 			},
 			expectUpgrade: &cosmovisor.UpgradeInfo{
-				Name: "chain2",
-				Info: `https://really.cool.network/downloads/v0/download.zip?sha256:dc48829b4126ae95bc0db316c66d4e9da5f3db95e212665b6080638cca77e998`,
+				Name:   "chain2",
+				Height: 49,
+				Info:   `https://really.cool.network/downloads/v0/download.zip?sha256:dc48829b4126ae95bc0db316c66d4e9da5f3db95e212665b6080638cca77e998`,
 			},
 		},
 		"consensus failure structured logging": {
@@ -152,8 +163,142 @@ func TestWaitForInfo(t *testing.T) {
 				`Jun  7 11:28:40 query-node-us-east1-0 cosmovisor[245614]: {"level":"error","module":"consensus","err":"UPGRADE \"citrine\" NEEDED at height: 1582700: https://github.com/provenance-io/provenance/releases/download/v1.4.1/plan-v1.4.1.json","stack":"goroutine 179 [running]:\nruntime/debug.Stack(0xc0018bbb48, 0x1d51c40, 0xc004550e90)\n\truntime/debug/stack.go:24 +0x9f\ngithub.com/tendermint/tendermint/consensus.(*State).receiveRoutine.func2(0xc0010f8a80, 0x22e04f0)\n\tgithub.com/tendermint/tendermint@v0.34.10/consensus/state.go:726 +0x5b\npanic(0x1d51c40, 0xc004550e90)\n\truntime/panic.go:965 +0x1b9\ngithub.com/cosmos/cosmos-sdk/x/upgrade.BeginBlocker(0x7fffe0a60dd1, 0xc, 0xc000c1dec0, 0x252eb58, 0xc0010240c0, 0x2566ed8, 0xc000fb28e0, 0xc000d628d0, 0x254e888, 0xc00011c150, ...)\n\tgithub.com/cosmos/cosmos-sdk@v0.42.4/x/upgrade/abci.go:70 +0x11cb\ngithub.com/cosmos/cosmos-sdk/x/upgrade.AppModule.BeginBlock(...)\n\tgithub.com/cosmos/cosmos-sdk@v0.42.4/x/upgrade/module.go:127\ngithub.com/cosmos/cosmos-sdk/types/module.(*Manager).BeginBlock(0xc000b9b0a0, 0x254e888, 0xc00011c150, 0x25668e8, 0xc001d970c0, 0xb, 0x0, 0xc003a64df0, 0xd, 0x18266c, ...)\n\tgithub.com/cosmos/cosmos-sdk@v0.42.4/types/module/module.go:338 +0x1b8\ngithub.com/provenance-io/provenance/app.(*App).BeginBlocker(...)\n\tgithub.com/provenance-io/provenance/app/app.go:639\ngithub.com/cosmos/cosmos-sdk/baseapp.(*BaseApp).BeginBlock(0xc0010ff860, 0xc002715e80, 0x20, 0x20, 0xb, 0x0, 0xc003a64df0, 0xd, 0x18266c, 0x75030f2, ...)\n\tgithub.com/cosmos/cosmos-sdk@v0.42.4/baseapp/abci.go:179 +0x638\ngithub.com/tendermint/tendermint/abci/client.(*localClient).BeginBlockSync(0xc000cb5740, 0xc002715e80, 0x20, 0x20, 0xb, 0x0, 0xc003a64df0, 0xd, 0x18266c, 0x75030f2, ...)\n\tgithub.com/tendermint/tendermint@v0.34.10/abci/client/local_client.go:274 +0xfa\ngithub.com/tendermint/tendermint/proxy.(*appConnConsensus).BeginBlockSync(0xc00113ab40, 0xc002715e80, 0x20, 0x20, 0xb, 0x0, 0xc003a64df0, 0xd, 0x18266c, 0x75030f2, ...)\n\tgithub.com/tendermint/tendermint@v0.34.10/proxy/app_conn.go:81 +0x75\ngithub.com/tendermint/tendermint/state.execBlockOnProxyApp(0x254f5a8, 0xc000101aa0, 0x255bb28, 0xc00113ab40, 0xc0013fde00, 0x2566a88, 0xc00113a790, 0x1, 0xc004ab2840, 0x20, ...)\n\tgithub.com/tendermint/tendermint@v0.34.10/state/execution.go:307 +0x51b\ngithub.com/tendermint/tendermint/state.(*BlockExecutor).ApplyBlock(0xc000c2e380, 0xb, 0x0, 0x0, 0x0, 0xc001e1b250, 0xd, 0x1, 0x18266b, 0xc004ab2840, ...)\n\tgithub.com/tendermint/tendermint@v0.34.10/state/execution.go:140 +0x168\ngithub.com/tendermint/tendermint/consensus.(*State).finalizeCommit(0xc0010f8a80, 0x18266c)\n\tgithub.com/tendermint/tendermint@v0.34.10/consensus/state.go:1635 +0xb48\ngithub.com/tendermint/tendermint/consensus.(*State).tryFinalizeCommit(0xc0010f8a80, 0x18266c)\n\tgithub.com/tendermint/tendermint@v0.34.10/consensus/state.go:1546 +0x428\ngithub.com/tendermint/tendermint/consensus.(*State).enterCommit.func1(0xc0010f8a80, 0xc000000000, 0x18266c)\n\tgithub.com/tendermint/tendermint@v0.34.10/consensus/state.go:1481 +0x8e\ngithub.com/tendermint/tendermint/consensus.(*State).enterCommit(0xc0010f8a80, 0x18266c, 0x0)\n\tgithub.com/tendermint/tendermint@v0.34.10/consensus/state.go:1519 +0x6be\ngithub.com/tendermint/tendermint/consensus.(*State).addVote(0xc0010f8a80, 0xc0077d7400, 0xc000f7ff80, 0x28, 0x22e28a0, 0xc0018c1c08, 0x11e2eb9)\n\tgithub.com/tendermint/tendermint@v0.34.10/consensus/state.go:2132 +0xde5\ngithub.com/tendermint/tendermint/consensus.(*State).tryAddVote(0xc0010f8a80, 0xc0077d7400, 0xc000f7ff80, 0x28, 0xc003f95100, 0xc00681d8c0, 0xc0279e9a3049885e)\n\tgithub.com/tendermint/tendermint@v0.34.10/consensus/state.go:1930 +0x56\ngithub.com/tendermint/tendermint/consensus.(*State).handleMsg(0xc0010f8a80, 0x2508380, 0xc0023de3e8, 0xc000f7ff80, 0x28)\n\tgithub.com/tendermint/tendermint@v0.34.10/consensus/state.go:838 +0x8cd\ngithub.com/tendermint/tendermint/consensus.(*State).receiveRoutine(0xc0010f8a80, 0x0)\n\tgithub.com/tendermint/tendermint@v0.34.10/consensus/state.go:762 +0x3f2\ncreated by github.com/tendermint/tendermint/consensus.(*State).OnStart\n\tgithub.com/tendermint/tendermint@v0.34.10/consensus/state.go:378 +0x8c5\n","time":"2021-06-07T11:28:40Z","message":"CONSENSUS FAILURE!!!"}` + "\n",
 			},
 			expectUpgrade: &cosmovisor.UpgradeInfo{
-				Name: "citrine",
-				Info: "https://github.com/provenance-io/provenance/releases/download/v1.4.1/plan-v1.4.1.json",
+				Name:   "citrine",
+				Height: 1582700,
+				Info:   "https://github.com/provenance-io/provenance/releases/download/v1.4.1/plan-v1.4.1.json",
+			},
+		},
+		"match time based plan": {
+			write: []string{
+				`01:00 ERR UPGRADE "timed" NEEDED at time: 2021-06-07T11:28:40Z: /app/plan.json` + "\n",
+				`panic: UPGRADE "timed" NEEDED at time: 2021-06-07T11:28:40Z: /app/plan.json` + "\n",
+			},
+			expectUpgrade: &cosmovisor.UpgradeInfo{
+				Name: "timed",
+				Time: time.Date(2021, 6, 7, 11, 28, 40, 0, time.UTC),
+				Info: "/app/plan.json",
+			},
+		},
+		"match time based plan with fractional seconds and offset": {
+			write: []string{
+				`01:00 ERR UPGRADE "timed" NEEDED at time: 2021-06-07T13:28:40.5+02:00: {}` + "\n",
+				`message="CONSENSUS FAILURE!!!" err="UPGRADE \"timed\" NEEDED at time: 2021-06-07T13:28:40.5+02:00: {}"` + "\n",
+			},
+			expectUpgrade: &cosmovisor.UpgradeInfo{
+				Name: "timed",
+				Time: time.Date(2021, 6, 7, 11, 28, 40, 500000000, time.UTC),
+				Info: "{}",
+			},
+		},
+		"match time based plan json": {
+			write: []string{
+				`{"level":"error","time":"2021-03-24T20:33:13Z","message":"UPGRADE \"jsontime\" NEEDED at time: 2021-03-24T20:33:13Z: /app/plan.json"}` + "\n",
+				`{"level":"error","module":"consensus","err":"UPGRADE \"jsontime\" NEEDED at time: 2021-03-24T20:33:13Z: /app/plan.json","message":"CONSENSUS FAILURE!!!"}` + "\n",
+			},
+			expectUpgrade: &cosmovisor.UpgradeInfo{
+				Name: "jsontime",
+				Time: time.Date(2021, 3, 24, 20, 33, 13, 0, time.UTC),
+				Info: "/app/plan.json",
+			},
+		},
+		"sdk v0.42 plain text info followed by fields": {
+			write: []string{
+				`I[2021-06-07|11:28:39.911] executed block                               module=state height=1582699 num_valid_txs=0 num_invalid_txs=0` + "\n",
+				`E[2021-06-07|11:28:40.123] UPGRADE "citrine" NEEDED at height: 1582700: https://github.com/provenance-io/provenance/releases/download/v1.4.1/plan-v1.4.1.json module=main` + "\n",
+				`E[2021-06-07|11:28:40.125] CONSENSUS FAILURE!!!                         module=consensus err="UPGRADE \"citrine\" NEEDED at height: 1582700: https://github.com/provenance-io/provenance/releases/download/v1.4.1/plan-v1.4.1.json" stack="goroutine 179 [running]:\nruntime/debug.Stack(0xc0018bbb48, 0x1d51c40, 0xc004550e90)"` + "\n",
+			},
+			expectUpgrade: &cosmovisor.UpgradeInfo{
+				Name:   "citrine",
+				Height: 1582700,
+				Info:   "https://github.com/provenance-io/provenance/releases/download/v1.4.1/plan-v1.4.1.json",
+			},
+		},
+		"sdk v0.45 plain text info with spaces and quoted fields": {
+			write: []string{
+				`11:28AM ERR UPGRADE "v2" NEEDED at height: 100: upgrade to v2, notes at https://example.com/v2 module=x/upgrade err="plan \"v2\" not handled" height=100` + "\n",
+				`11:28AM ERR CONSENSUS FAILURE!!! err="UPGRADE \"v2\" NEEDED at height: 100: upgrade to v2, notes at https://example.com/v2" module=consensus stack="goroutine 91 [running]:"` + "\n",
+			},
+			expectUpgrade: &cosmovisor.UpgradeInfo{
+				Name:   "v2",
+				Height: 100,
+				Info:   "upgrade to v2, notes at https://example.com/v2",
+			},
+		},
+		"sdk v0.45 plain inline json with spaces": {
+			write: []string{
+				`11:28AM ERR UPGRADE "v2" NEEDED at height: 100: {"binaries": {"linux/amd64": "https://example.com/v2.zip?checksum=sha256:dc48829b4126ae95bc0db316c66d4e9da5f3db95e212665b6080638cca77e998"}} module=x/upgrade` + "\n",
+				`panic: UPGRADE "v2" NEEDED at height: 100: {"binaries": {"linux/amd64": "https://example.com/v2.zip?checksum=sha256:dc48829b4126ae95bc0db316c66d4e9da5f3db95e212665b6080638cca77e998"}}` + "\n",
+			},
+			expectUpgrade: &cosmovisor.UpgradeInfo{
+				Name:   "v2",
+				Height: 100,
+				Info:   `{"binaries": {"linux/amd64": "https://example.com/v2.zip?checksum=sha256:dc48829b4126ae95bc0db316c66d4e9da5f3db95e212665b6080638cca77e998"}}`,
+			},
+		},
+		"sdk v0.46 colored console output": {
+			write: []string{
+				"\x1b[90m11:28AM\x1b[0m \x1b[31mERR\x1b[0m UPGRADE \"v3\" NEEDED at height: 200: {\"binaries\": {\"linux/amd64\": \"https://example.com/v3.zip\"}} \x1b[36mmodule=\x1b[0mx/upgrade\n",
+				"\x1b[90m11:28AM\x1b[0m \x1b[31mERR\x1b[0m CONSENSUS FAILURE!!! \x1b[36merr=\x1b[0m\"UPGRADE \\\"v3\\\" NEEDED at height: 200\" \x1b[36mmodule=\x1b[0mconsensus\n",
+			},
+			expectUpgrade: &cosmovisor.UpgradeInfo{
+				Name:   "v3",
+				Height: 200,
+				Info:   `{"binaries": {"linux/amd64": "https://example.com/v3.zip"}}`,
+			},
+		},
+		"sdk v0.46 plain pretty printed json": {
+			write: []string{
+				`11:28AM ERR UPGRADE "v3" NEEDED at height: 200: {` + "\n",
+				`  "binaries": {` + "\n",
+				`    "linux/amd64": "https://example.com/v3 linux.zip"` + "\n",
+				`  }` + "\n",
+				`} module=x/upgrade` + "\n",
+				`11:28AM ERR CONSENSUS FAILURE!!! err="UPGRADE \"v3\" NEEDED at height: 200" module=consensus` + "\n",
+			},
+			expectUpgrade: &cosmovisor.UpgradeInfo{
+				Name:   "v3",
+				Height: 200,
+				Info:   "{\n  \"binaries\": {\n    \"linux/amd64\": \"https://example.com/v3 linux.zip\"\n  }\n}",
+			},
+		},
+		"sdk v0.47 json pretty printed json": {
+			write: []string{
+				`{"level":"error","module":"x/upgrade","time":"2023-03-24T20:33:13Z","message":"UPGRADE \"v4\" NEEDED at height: 300: {\n  \"binaries\": {\n    \"linux/amd64\": \"https://example.com/v4.zip\"\n  }\n}"}` + "\n",
+				`{"level":"error","module":"consensus","err":"UPGRADE \"v4\" NEEDED at height: 300","time":"2023-03-24T20:33:13Z","message":"CONSENSUS FAILURE!!!"}` + "\n",
+			},
+			expectUpgrade: &cosmovisor.UpgradeInfo{
+				Name:   "v4",
+				Height: 300,
+				Info:   "{\n  \"binaries\": {\n    \"linux/amd64\": \"https://example.com/v4.zip\"\n  }\n}",
+			},
+		},
+		"sdk v0.47 json text info with spaces": {
+			write: []string{
+				`{"level":"error","module":"x/upgrade","time":"2023-03-24T20:33:13Z","message":"UPGRADE \"v4\" NEEDED at height: 300: see the release notes key=value"}` + "\n",
+				`{"level":"error","module":"consensus","err":"UPGRADE \"v4\" NEEDED at height: 300","message":"CONSENSUS FAILURE!!!"}` + "\n",
+			},
+			expectUpgrade: &cosmovisor.UpgradeInfo{
+				Name:   "v4",
+				Height: 300,
+				Info:   "see the release notes key=value",
+			},
+		},
+		"unterminated json keeps the first line": {
+			write: []string{
+				`11:28AM ERR UPGRADE "v5" NEEDED at height: 400: {"binaries": {` + "\n",
+				`11:28AM INF something else module=main` + "\n",
+				`panic: UPGRADE "v5" NEEDED at height: 400: {"binaries": {` + "\n",
+			},
+			expectUpgrade: &cosmovisor.UpgradeInfo{
+				Name:   "v5",
+				Height: 400,
+				Info:   `{"binaries": {`,
+			},
+		},
+		"unknown due at - ignored": {
+			write: []string{
+				`01:00 ERR UPGRADE "odd" NEEDED at epoch: 12: {}` + "\n",
+				`panic: UPGRADE "odd" NEEDED at epoch: 12: {}` + "\n",
 			},
 		},
 	}
@@ -180,7 +325,20 @@ func TestWaitForInfo(t *testing.T) {
 				return
 			}
 			require.NoError(t, err)
+			if tc.expectUpgrade != nil && info != nil {
+				// compare times by instant, the parsed location depends on the offset in the log
+				require.True(t, tc.expectUpgrade.Time.Equal(info.Time), "%s != %s", tc.expectUpgrade.Time, info.Time)
+				info.Time = tc.expectUpgrade.Time
+			}
 			require.Equal(t, tc.expectUpgrade, info)
 		})
 	}
 }
+
+func TestUpgradeInfoDueAt(t *testing.T) {
+	height := &cosmovisor.UpgradeInfo{Name: "h", Height: 1582700}
+	require.Equal(t, "height: 1582700", height.DueAt())
+
+	timed := &cosmovisor.UpgradeInfo{Name: "t", Time: time.Date(2021, 6, 7, 13, 28, 40, 0, time.FixedZone("", 2*60*60))}
+	require.Equal(t, "time: 2021-06-07T11:28:40Z", timed.DueAt())
+}
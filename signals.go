@@ -0,0 +1,32 @@
+package cosmovisor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// signalNames maps the signal names accepted in config to signals, without the SIG prefix
+var signalNames = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"TERM": syscall.SIGTERM,
+	"KILL": syscall.SIGKILL,
+}
+
+// parseSignal parses a signal name like SIGTERM or TERM (case insensitive)
+func parseSignal(name string) (syscall.Signal, error) {
+	key := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), "SIG")
+	sig, ok := signalNames[key]
+	if !ok {
+		known := make([]string, 0, len(signalNames))
+		for n := range signalNames {
+			known = append(known, "SIG"+n)
+		}
+		sort.Strings(known)
+		return 0, fmt.Errorf("unknown signal %q, expected one of %s", name, strings.Join(known, ", "))
+	}
+	return sig, nil
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+// +build !windows
+
+package cosmovisor
+
+import "syscall"
+
+func init() {
+	signalNames["USR1"] = syscall.SIGUSR1
+	signalNames["USR2"] = syscall.SIGUSR2
+}
@@ -0,0 +1,329 @@
+package cosmovisor
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+var (
+	// ErrUnsigned is returned when a downloaded binary has no signature
+	ErrUnsigned = errors.New("binary is not signed")
+	// ErrUntrustedKey is returned when a downloaded binary is signed, but not by a key in the keyring
+	ErrUntrustedKey = errors.New("signed by an untrusted key")
+)
+
+const (
+	minisignAlgLegacy  = "Ed"
+	minisignAlgHashed  = "ED"
+	minisignComment    = "untrusted comment:"
+	minisignTrusted    = "trusted comment: "
+	sshKeyType         = "ssh-ed25519"
+	sshSigMagic        = "SSHSIG"
+	sshSigArmorBegin   = "-----BEGIN SSH SIGNATURE-----"
+	sshSigArmorEnd     = "-----END SSH SIGNATURE-----"
+	sshSigNamespace    = "file"
+	minisignKeyLen     = 2 + 8 + ed25519.PublicKeySize
+	minisignSigLen     = 2 + 8 + ed25519.SignatureSize
+	signatureExtension = ".minisig"
+	sshSigExtension    = ".sig"
+)
+
+// Keyring holds the release keys trusted to sign upgrade binaries
+type Keyring struct {
+	keys []trustedKey
+}
+
+// trustedKey is an ed25519 key from the keyring, in either minisign or ssh format
+type trustedKey struct {
+	pub ed25519.PublicKey
+	// id is the minisign key id, nil for ssh keys
+	id []byte
+	// name describes the key in logs and errors
+	name string
+}
+
+// LoadKeyring reads a keyring file, holding one public key per line, either
+// a minisign public key (the base64 line of a minisign .pub file) or an ssh-ed25519 key as in authorized_keys.
+// Blank lines, lines starting with # and minisign's untrusted comments are skipped.
+func LoadKeyring(path string) (*Keyring, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keyring := &Keyring{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, minisignComment) {
+			continue
+		}
+		key, err := parseTrustedKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, n, err)
+		}
+		keyring.keys = append(keyring.keys, key)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(keyring.keys) == 0 {
+		return nil, fmt.Errorf("%s has no keys", path)
+	}
+	return keyring, nil
+}
+
+// parseTrustedKey parses a single keyring line
+func parseTrustedKey(line string) (trustedKey, error) {
+	fields := strings.Fields(line)
+	if strings.HasPrefix(fields[0], "ssh-") || strings.HasPrefix(fields[0], "ecdsa-") {
+		if fields[0] != sshKeyType || len(fields) < 2 {
+			return trustedKey{}, fmt.Errorf("unsupported ssh key type %s, only %s is supported", fields[0], sshKeyType)
+		}
+		blob, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return trustedKey{}, fmt.Errorf("decoding ssh key: %w", err)
+		}
+		pub, err := parseSSHPublicKey(blob)
+		if err != nil {
+			return trustedKey{}, err
+		}
+		name := "ssh key"
+		if len(fields) > 2 {
+			name += " " + strings.Join(fields[2:], " ")
+		}
+		return trustedKey{pub: pub, name: name}, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(fields[0])
+	if err != nil || len(raw) != minisignKeyLen || string(raw[:2]) != minisignAlgLegacy {
+		return trustedKey{}, errors.New("not a minisign or ssh-ed25519 public key")
+	}
+	id := raw[2:10]
+	return trustedKey{pub: ed25519.PublicKey(raw[10:]), id: id, name: "minisign key " + minisignKeyID(id)}, nil
+}
+
+// minisignKeyID formats a key id the way minisign prints it, as a little endian number
+func minisignKeyID(id []byte) string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(id))
+}
+
+// Verify checks that sig is a minisign or ssh signature of the file at path, made by a key in the keyring.
+// It returns the name of the key that made it.
+func (k *Keyring) Verify(path string, sig []byte) (string, error) {
+	text := strings.TrimSpace(string(sig))
+	switch {
+	case strings.HasPrefix(text, sshSigArmorBegin):
+		return k.verifySSH(path, text)
+	case strings.HasPrefix(text, minisignComment):
+		return k.verifyMinisign(path, text)
+	default:
+		return "", errors.New("not a minisign or ssh signature")
+	}
+}
+
+// verifyMinisign checks a minisign signature, both over the file and over its trusted comment
+func (k *Keyring) verifyMinisign(path, text string) (string, error) {
+	lines := strings.Split(text, "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], minisignTrusted) {
+		return "", errors.New("malformed minisign signature")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(raw) != minisignSigLen {
+		return "", errors.New("malformed minisign signature")
+	}
+	alg, id, signature := string(raw[:2]), raw[2:10], raw[10:]
+
+	var key *trustedKey
+	for i := range k.keys {
+		if k.keys[i].id != nil && bytes.Equal(k.keys[i].id, id) {
+			key = &k.keys[i]
+			break
+		}
+	}
+	if key == nil {
+		return "", fmt.Errorf("%w: minisign key %s", ErrUntrustedKey, minisignKeyID(id))
+	}
+
+	var msg []byte
+	switch alg {
+	case minisignAlgHashed:
+		h, _ := blake2b.New512(nil)
+		if msg, err = hashFile(path, h); err != nil {
+			return "", err
+		}
+	case minisignAlgLegacy:
+		if msg, err = os.ReadFile(path); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unsupported minisign signature algorithm %q", alg)
+	}
+	if !ed25519.Verify(key.pub, msg, signature) {
+		return "", fmt.Errorf("invalid signature by %s", key.name)
+	}
+
+	// the trusted comment is signed too, so it can't be swapped
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil {
+		return "", errors.New("malformed minisign signature")
+	}
+	comment := strings.TrimSuffix(strings.TrimPrefix(lines[2], minisignTrusted), "\r")
+	if !ed25519.Verify(key.pub, append(append([]byte{}, signature...), comment...), global) {
+		return "", fmt.Errorf("invalid trusted comment signature by %s", key.name)
+	}
+	return key.name, nil
+}
+
+// verifySSH checks an ssh signature as made by `ssh-keygen -Y sign -n file`
+func (k *Keyring) verifySSH(path, text string) (string, error) {
+	body := strings.TrimSuffix(strings.TrimPrefix(text, sshSigArmorBegin), sshSigArmorEnd)
+	if !strings.HasSuffix(text, sshSigArmorEnd) {
+		return "", errors.New("malformed ssh signature")
+	}
+	blob, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(body), ""))
+	if err != nil {
+		return "", fmt.Errorf("malformed ssh signature: %w", err)
+	}
+
+	if !bytes.HasPrefix(blob, []byte(sshSigMagic)) {
+		return "", errors.New("malformed ssh signature")
+	}
+	r := sshReader(blob[len(sshSigMagic):])
+	version := r.uint32()
+	pubBlob := r.string()
+	namespace := r.string()
+	reserved := r.string()
+	hashAlg := r.string()
+	sigBlob := r.string()
+	if r.failed() {
+		return "", errors.New("malformed ssh signature")
+	}
+	if version != 1 {
+		return "", fmt.Errorf("unsupported ssh signature version %d", version)
+	}
+	if string(namespace) != sshSigNamespace {
+		return "", fmt.Errorf("ssh signature is for namespace %q, expected %q", namespace, sshSigNamespace)
+	}
+
+	pub, err := parseSSHPublicKey(pubBlob)
+	if err != nil {
+		return "", err
+	}
+	var key *trustedKey
+	for i := range k.keys {
+		if k.keys[i].pub.Equal(pub) {
+			key = &k.keys[i]
+			break
+		}
+	}
+	if key == nil {
+		return "", fmt.Errorf("%w: ssh key %s", ErrUntrustedKey, base64.StdEncoding.EncodeToString(pubBlob))
+	}
+
+	sr := sshReader(sigBlob)
+	sigType, signature := sr.string(), sr.string()
+	if sr.failed() || string(sigType) != sshKeyType || len(signature) != ed25519.SignatureSize {
+		return "", errors.New("malformed ssh signature")
+	}
+
+	var h hash.Hash
+	switch string(hashAlg) {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return "", fmt.Errorf("unsupported ssh signature hash %q", hashAlg)
+	}
+	digest, err := hashFile(path, h)
+	if err != nil {
+		return "", err
+	}
+
+	var signed bytes.Buffer
+	signed.WriteString(sshSigMagic)
+	for _, field := range [][]byte{namespace, reserved, hashAlg, digest} {
+		writeSSHString(&signed, field)
+	}
+	if !ed25519.Verify(key.pub, signed.Bytes(), signature) {
+		return "", fmt.Errorf("invalid signature by %s", key.name)
+	}
+	return key.name, nil
+}
+
+// parseSSHPublicKey parses an ssh-ed25519 public key in ssh wire format
+func parseSSHPublicKey(blob []byte) (ed25519.PublicKey, error) {
+	r := sshReader(blob)
+	keyType, key := r.string(), r.string()
+	if r.failed() || string(keyType) != sshKeyType || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("not an %s key", sshKeyType)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// sshReader reads the fields of ssh wire format data, it is set to nil once it runs out
+type sshReader []byte
+
+func (r *sshReader) uint32() uint32 {
+	if *r == nil || len(*r) < 4 {
+		*r = nil
+		return 0
+	}
+	v := binary.BigEndian.Uint32(*r)
+	*r = (*r)[4:]
+	return v
+}
+
+func (r *sshReader) string() []byte {
+	n := r.uint32()
+	if *r == nil || uint32(len(*r)) < n {
+		*r = nil
+		return nil
+	}
+	v := (*r)[:n]
+	*r = (*r)[n:]
+	return v
+}
+
+func (r *sshReader) failed() bool {
+	return *r == nil
+}
+
+// writeSSHString writes b as an ssh wire format string
+func writeSSHString(w *bytes.Buffer, b []byte) {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(b)))
+	w.Write(n[:])
+	w.Write(b)
+}
+
+// hashFile returns the digest of the file at path
+func hashFile(path string, h hash.Hash) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// signatureSuffixes are appended to the url of a download to find its signature, in this order
+var signatureSuffixes = []string{signatureExtension, sshSigExtension}
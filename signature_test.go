@@ -0,0 +1,232 @@
+package cosmovisor_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/blake2b"
+
+	"github.com/provenance-io/cosmovisor"
+)
+
+// testKey is a locally generated release key
+type testKey struct {
+	pub  ed25519.PublicKey
+	priv ed25519.PrivateKey
+	id   []byte
+}
+
+func newTestKey(t *testing.T) testKey {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	id := make([]byte, 8)
+	_, err = rand.Read(id)
+	require.NoError(t, err)
+	return testKey{pub: pub, priv: priv, id: id}
+}
+
+// minisignPublicKey returns the key as in a minisign .pub file
+func (k testKey) minisignPublicKey() string {
+	raw := append(append([]byte("Ed"), k.id...), k.pub...)
+	return "untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(raw)
+}
+
+// minisign signs data like `minisign -S`, or like `minisign -S -l` for the legacy format
+func (k testKey) minisign(data []byte, legacy bool) []byte {
+	alg, msg := "ED", blake2b.Sum512(data)
+	signed := msg[:]
+	if legacy {
+		alg, signed = "Ed", data
+	}
+	sig := ed25519.Sign(k.priv, signed)
+	comment := "timestamp:1650000000\tfile:autod"
+	global := ed25519.Sign(k.priv, append(append([]byte{}, sig...), comment...))
+	raw := append(append([]byte(alg), k.id...), sig...)
+	return []byte(fmt.Sprintf("untrusted comment: signature from minisign secret key\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(raw), comment, base64.StdEncoding.EncodeToString(global)))
+}
+
+func sshString(b []byte) []byte {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(b)))
+	return append(n[:], b...)
+}
+
+func (k testKey) sshWireKey() []byte {
+	return append(sshString([]byte("ssh-ed25519")), sshString(k.pub)...)
+}
+
+// sshPublicKey returns the key as in authorized_keys
+func (k testKey) sshPublicKey() string {
+	return "ssh-ed25519 " + base64.StdEncoding.EncodeToString(k.sshWireKey()) + " release@example.com"
+}
+
+// sshSign signs data like `ssh-keygen -Y sign -n <namespace>`
+func (k testKey) sshSign(data []byte, namespace string) []byte {
+	digest := sha512.Sum512(data)
+	var signed bytes.Buffer
+	signed.WriteString("SSHSIG")
+	for _, field := range [][]byte{[]byte(namespace), nil, []byte("sha512"), digest[:]} {
+		signed.Write(sshString(field))
+	}
+	sig := ed25519.Sign(k.priv, signed.Bytes())
+
+	var blob bytes.Buffer
+	blob.WriteString("SSHSIG")
+	blob.Write([]byte{0, 0, 0, 1})
+	for _, field := range [][]byte{k.sshWireKey(), []byte(namespace), nil, []byte("sha512"),
+		append(sshString([]byte("ssh-ed25519")), sshString(sig)...)} {
+		blob.Write(sshString(field))
+	}
+	encoded := base64.StdEncoding.EncodeToString(blob.Bytes())
+	var armored strings.Builder
+	armored.WriteString("-----BEGIN SSH SIGNATURE-----\n")
+	for len(encoded) > 70 {
+		armored.WriteString(encoded[:70] + "\n")
+		encoded = encoded[70:]
+	}
+	armored.WriteString(encoded + "\n-----END SSH SIGNATURE-----\n")
+	return []byte(armored.String())
+}
+
+func TestDoUpgradeVerifiesSignature(t *testing.T) {
+	binary, err := os.ReadFile("testdata/repo/raw_binary/autod")
+	require.NoError(t, err)
+	trustedMinisign, trustedSSH, untrusted := newTestKey(t), newTestKey(t), newTestKey(t)
+
+	keyring := filepath.Join(t.TempDir(), "trusted_keys")
+	require.NoError(t, os.WriteFile(keyring, []byte(strings.Join([]string{
+		"# release keys",
+		trustedMinisign.minisignPublicKey(),
+		"",
+		trustedSSH.sshPublicKey(),
+	}, "\n")+"\n"), 0600))
+
+	cases := map[string]struct {
+		// files served next to autod
+		files  map[string][]byte
+		local  bool
+		errMsg string
+	}{
+		"minisign": {
+			files: map[string][]byte{"autod.minisig": trustedMinisign.minisign(binary, false)},
+		},
+		"minisign legacy": {
+			files: map[string][]byte{"autod.minisig": trustedMinisign.minisign(binary, true)},
+		},
+		"ssh signature": {
+			files: map[string][]byte{"autod.sig": trustedSSH.sshSign(binary, "file")},
+		},
+		"ssh signature of a local file": {
+			files: map[string][]byte{"autod.sig": trustedSSH.sshSign(binary, "file")},
+			local: true,
+		},
+		"unsigned": {
+			errMsg: cosmovisor.ErrUnsigned.Error(),
+		},
+		"untrusted minisign key": {
+			files:  map[string][]byte{"autod.minisig": untrusted.minisign(binary, false)},
+			errMsg: cosmovisor.ErrUntrustedKey.Error(),
+		},
+		"untrusted ssh key": {
+			files:  map[string][]byte{"autod.sig": untrusted.sshSign(binary, "file")},
+			errMsg: cosmovisor.ErrUntrustedKey.Error(),
+		},
+		"minisign key id of a trusted key but signed by another": {
+			files: map[string][]byte{"autod.minisig": testKey{
+				pub: untrusted.pub, priv: untrusted.priv, id: trustedMinisign.id,
+			}.minisign(binary, false)},
+			errMsg: "invalid signature",
+		},
+		"signature of other content": {
+			files:  map[string][]byte{"autod.minisig": trustedMinisign.minisign([]byte("something else"), false)},
+			errMsg: "invalid signature",
+		},
+		"ssh signature for another namespace": {
+			files:  map[string][]byte{"autod.sig": trustedSSH.sshSign(binary, "git")},
+			errMsg: `namespace "git"`,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			files := map[string][]byte{"autod": binary}
+			for file, content := range tc.files {
+				files[file] = content
+			}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				content, ok := files[strings.TrimPrefix(r.URL.Path, "/")]
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+				_, _ = w.Write(content)
+			}))
+			defer server.Close()
+
+			src := server.URL + "/autod"
+			if tc.local {
+				dir := t.TempDir()
+				for file, content := range files {
+					require.NoError(t, os.WriteFile(filepath.Join(dir, file), content, 0644))
+				}
+				src = filepath.Join(dir, "autod")
+			}
+
+			home := copyTestData(t, "download")
+			cfg := &cosmovisor.Config{Home: home, Name: "autod", AllowDownloadBinaries: true, TrustedKeys: keyring}
+			info := &cosmovisor.UpgradeInfo{
+				Name: "amazonas",
+				Info: fmt.Sprintf(`{"binaries":{"%s": "%s"}}`, cosmovisor.OSArch(), src),
+			}
+
+			err := cosmovisor.DoUpgrade(cfg, info)
+			current, cerr := cfg.CurrentUpgradeName()
+			require.NoError(t, cerr)
+			if tc.errMsg != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.errMsg)
+				require.Equal(t, "genesis", current)
+				_, err = os.Stat(cfg.UpgradeDir("amazonas"))
+				require.True(t, os.IsNotExist(err))
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, "amazonas", current)
+		})
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "keys")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+
+	_, err := cosmovisor.LoadKeyring(write(newTestKey(t).minisignPublicKey() + "\n" + newTestKey(t).sshPublicKey() + "\n"))
+	require.NoError(t, err)
+
+	_, err = cosmovisor.LoadKeyring(write("# nothing\n\n"))
+	require.Error(t, err)
+
+	_, err = cosmovisor.LoadKeyring(write("ssh-rsa AAAAB3NzaC1yc2E= rsa@example.com\n"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "line 1")
+
+	_, err = cosmovisor.LoadKeyring(write("not a key\n"))
+	require.Error(t, err)
+}
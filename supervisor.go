@@ -0,0 +1,275 @@
+package cosmovisor
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Supervisor runs the daemon, upgrading and restarting it as configured.
+// It sets up signal handling once for its whole run, and forwards signals to whichever daemon process is current.
+type Supervisor struct {
+	stdout io.Writer
+	stderr io.Writer
+	cfg    *Config
+	// proc is the currently running daemon process, nil between runs
+	proc *os.Process
+	// stop is called when a terminating signal arrives while no daemon is running
+	stop  context.CancelFunc
+	mutex sync.Mutex
+	// stopping is set once a terminating signal was received, so the daemon isn't restarted
+	stopping bool
+	// running is set while Run holds the pid file, Launch leaves it alone then
+	running bool
+}
+
+// NewSupervisor returns a Supervisor for the daemon in cfg, writing the daemon's output to stdout and stderr
+func NewSupervisor(cfg *Config, stdout, stderr io.Writer) *Supervisor {
+	return &Supervisor{cfg: cfg, stdout: stdout, stderr: stderr}
+}
+
+// Run launches the daemon with args and keeps it running, upgrading and restarting it as configured,
+// until it exits for good or ctx is done. The configured signals are forwarded to the daemon while Run is active.
+//
+// A terminating signal (SIGINT, SIGTERM, SIGQUIT) means the daemon won't be restarted once it exits.
+// If ctx is done, the daemon is stopped like it is for an upgrade and ctx.Err() is returned.
+func (s *Supervisor) Run(ctx context.Context, args []string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	forward := make([]os.Signal, 0, len(s.cfg.ForwardSignals))
+	for _, name := range s.cfg.ForwardSignals {
+		sig, err := parseSignal(name)
+		if err != nil {
+			return err
+		}
+		forward = append(forward, sig)
+	}
+
+	s.mutex.Lock()
+	s.stop = cancel
+	s.stopping = false
+	s.mutex.Unlock()
+
+	sigs := make(chan os.Signal, 1)
+	if len(forward) > 0 {
+		signal.Notify(sigs, forward...)
+	}
+	forwarding := make(chan struct{})
+	go func() {
+		defer close(forwarding)
+		s.forwardSignals(ctx, sigs)
+	}()
+	// stop listening before the forwarder, so no signal is left unhandled
+	defer func() {
+		signal.Stop(sigs)
+		cancel()
+		<-forwarding
+	}()
+
+	// the pid file names cosmovisor itself for the whole run, so restores and rollbacks are refused
+	// during restart delays and upgrades too, not only while the daemon runs
+	if err := writePIDFile(s.cfg, os.Getpid()); err != nil {
+		Logger.Printf("writing pid file: %v", err)
+	}
+	s.running = true
+	defer func() {
+		s.running = false
+		removePIDFile(s.cfg)
+	}()
+
+	if err := CleanStaging(s.cfg); err != nil {
+		Logger.Printf("cleaning up staged downloads: %v", err)
+	}
+
+	if s.cfg.PlanEndpoint != "" {
+		polling := make(chan struct{})
+		go func() {
+			defer close(polling)
+			NewPlanPoller(s.cfg).Run(ctx)
+		}()
+		defer func() {
+			cancel()
+			<-polling
+		}()
+	}
+
+	restarter := NewRestarter(s.cfg)
+	for {
+		doUpgrade, err := s.Launch(ctx, args)
+		if ctx.Err() != nil && !s.isStopping() {
+			return ctx.Err()
+		}
+		if s.isStopping() {
+			// we were told to stop, so however the daemon exited is how we exit
+			return err
+		}
+
+		// if RestartAfterUpgrade, we launch after a successful upgrade (only condition Launch returns nil)
+		if err == nil && doUpgrade {
+			if !s.cfg.RestartAfterUpgrade {
+				return nil
+			}
+			restarter.Reset()
+			continue
+		}
+
+		// otherwise the daemon exited on its own, and the restart policy decides
+		delay, restart, rerr := restarter.Next(err, time.Now())
+		if rerr != nil {
+			return rerr
+		}
+		if !restart {
+			return err
+		}
+		Logger.Printf("daemon %s, restarting in %s", exitReason(err), delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if s.isStopping() {
+				return err
+			}
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// forwardSignals passes every signal received on sigs to the current daemon process until ctx is done
+func (s *Supervisor) forwardSignals(ctx context.Context, sigs <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-sigs:
+			s.mutex.Lock()
+			proc := s.proc
+			if isTerminating(sig) {
+				s.stopping = true
+				if proc == nil {
+					// nothing to pass it to, so stop waiting for the next run
+					s.stop()
+				}
+			}
+			s.mutex.Unlock()
+
+			if proc == nil {
+				Logger.Printf("received %s while the daemon is not running", sig)
+				continue
+			}
+			if err := proc.Signal(sig); err != nil {
+				Logger.Printf("forwarding %s to daemon: %v", sig, err)
+			}
+		}
+	}
+}
+
+// isStopping returns true once a terminating signal was received
+func (s *Supervisor) isStopping() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.stopping
+}
+
+// setProcess records the current daemon process, for signal forwarding
+func (s *Supervisor) setProcess(proc *os.Process) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.proc = proc
+}
+
+// Launch runs the daemon once and returns when it exits,
+// either when it dies, or *after* a successful upgrade.
+// If ctx is done while the daemon runs, the daemon is stopped like it is for an upgrade.
+func (s *Supervisor) Launch(ctx context.Context, args []string) (bool, error) {
+	cfg := s.cfg
+	bin, err := cfg.CurrentBin()
+	if err != nil {
+		return false, WithExitCode(ExitCodeSymlink, fmt.Errorf("error creating symlink to genesis: %w", err))
+	}
+
+	if e := EnsureBinary(bin); e != nil {
+		return false, WithExitCode(ExitCodeBinary, fmt.Errorf("current binary invalid: %w", e))
+	}
+
+	cmd := exec.Command(bin, args...)
+	// we manage the pipes ourselves rather than using cmd.StdoutPipe, as Wait closes those
+	// as soon as the process exits, losing whatever it wrote while shutting down
+	outpipe, outw, e := os.Pipe()
+	if e != nil {
+		return false, e
+	}
+	defer outpipe.Close()
+
+	errpipe, errw, e := os.Pipe()
+	if e != nil {
+		outw.Close()
+		return false, e
+	}
+	defer errpipe.Close()
+	cmd.Stdout, cmd.Stderr = outw, errw
+
+	scanOut := bufio.NewScanner(io.TeeReader(outpipe, s.stdout))
+	scanErr := bufio.NewScanner(io.TeeReader(errpipe, s.stderr))
+	scanOut.Split(ScanLines)
+	scanErr.Split(ScanLines)
+
+	e = cmd.Start()
+	// the child has its own copies of the write ends now
+	outw.Close()
+	errw.Close()
+	if e != nil {
+		return false, WithExitCode(ExitCodeBinary, fmt.Errorf("launching process %s %s: %w", bin, strings.Join(args, " "), e))
+	}
+	s.setProcess(cmd.Process)
+	if !s.running {
+		if err := writePIDFile(cfg, cmd.Process.Pid); err != nil {
+			Logger.Printf("writing pid file: %v", err)
+		}
+	}
+
+	// stop the daemon if ctx is done before it exits
+	exited := make(chan struct{})
+	watching := make(chan struct{})
+	go func() {
+		defer close(watching)
+		select {
+		case <-ctx.Done():
+			StopProcess(cfg, cmd.Process, exited)
+		case <-exited:
+		}
+	}()
+
+	// three ways to exit - command ends, find regexp in scanOut, find regexp in scanErr
+	upgradeInfo, err := WaitForUpgradeOrExit(cfg, cmd, scanOut, scanErr)
+	s.setProcess(nil)
+	if !s.running {
+		removePIDFile(cfg)
+	}
+	close(exited)
+	<-watching
+	if err != nil {
+		return false, err
+	}
+
+	if upgradeInfo != nil {
+		return true, DoUpgrade(cfg, upgradeInfo)
+	}
+
+	return false, nil
+}
+
+// isTerminating returns true for the signals that tell us to shut down
+func isTerminating(sig os.Signal) bool {
+	return sig == syscall.SIGINT || sig == syscall.SIGTERM || sig == syscall.SIGQUIT
+}
@@ -0,0 +1,55 @@
+//go:build !windows
+// +build !windows
+
+package cosmovisor_test
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"syscall"
+
+	"github.com/provenance-io/cosmovisor"
+)
+
+func (s *supervisorTestSuite) TestForwardsSignals() {
+	cfg := s.supervisorConfig("SIGUSR1", "SIGTERM")
+	var stdout, stderr syncBuffer
+	done := s.runSupervisor(context.Background(), cosmovisor.NewSupervisor(cfg, &stdout, &stderr), "signals")
+
+	s.waitForOutput(&stdout, "Ready")
+	s.Require().NoError(syscall.Kill(syscall.Getpid(), syscall.SIGUSR1))
+
+	s.Require().NoError(<-done)
+	s.Require().Equal("Ready\nGot USR1\n", stdout.String())
+}
+
+func (s *supervisorTestSuite) TestTerminatingSignalPreventsRestart() {
+	cfg := s.supervisorConfig("SIGTERM")
+	cfg.RestartPolicy = cosmovisor.RestartAlways
+	var stdout, stderr syncBuffer
+	done := s.runSupervisor(context.Background(), cosmovisor.NewSupervisor(cfg, &stdout, &stderr), "signals")
+
+	s.waitForOutput(&stdout, "Ready")
+	s.Require().NoError(syscall.Kill(syscall.Getpid(), syscall.SIGTERM))
+
+	// the daemon's exit is passed on, and it isn't restarted
+	err := <-done
+	var exitErr *exec.ExitError
+	s.Require().True(errors.As(err, &exitErr), "%v", err)
+	s.Require().Equal(3, exitErr.ExitCode())
+	s.Require().Equal("Ready\nGot TERM\n", stdout.String())
+}
+
+func (s *supervisorTestSuite) TestRunCanBeRepeated() {
+	// signal handling is cleaned up after each run, so a second run works the same
+	cfg := s.supervisorConfig("SIGUSR1")
+	for i := 0; i < 2; i++ {
+		var stdout, stderr syncBuffer
+		done := s.runSupervisor(context.Background(), cosmovisor.NewSupervisor(cfg, &stdout, &stderr), "signals")
+		s.waitForOutput(&stdout, "Ready")
+		s.Require().NoError(syscall.Kill(syscall.Getpid(), syscall.SIGUSR1))
+		s.Require().NoError(<-done, i)
+		s.Require().Equal("Ready\nGot USR1\n", stdout.String(), i)
+	}
+}
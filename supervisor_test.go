@@ -0,0 +1,117 @@
+package cosmovisor_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/provenance-io/cosmovisor"
+)
+
+type supervisorTestSuite struct {
+	suite.Suite
+}
+
+func TestSupervisorTestSuite(t *testing.T) {
+	suite.Run(t, new(supervisorTestSuite))
+}
+
+// syncBuffer is a bytes.Buffer safe to read while the daemon writes to it
+type syncBuffer struct {
+	buf   bytes.Buffer
+	mutex sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+// supervisorConfig returns a config for the supervisor testdata that only forwards the given signals
+func (s *supervisorTestSuite) supervisorConfig(signals ...string) *cosmovisor.Config {
+	home := copyTestData(s.T(), "supervisor")
+	return &cosmovisor.Config{
+		Home:           home,
+		Name:           "dummyd",
+		ShutdownSignal: "SIGTERM",
+		ShutdownGrace:  5 * time.Second,
+		ForwardSignals: signals,
+	}
+}
+
+// runSupervisor runs the supervisor in the background, returning a channel with its result
+func (s *supervisorTestSuite) runSupervisor(ctx context.Context, sup *cosmovisor.Supervisor, args ...string) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- sup.Run(ctx, args) }()
+	return done
+}
+
+// waitForOutput waits until out contains text
+func (s *supervisorTestSuite) waitForOutput(out *syncBuffer, text string) {
+	s.Require().Eventually(func() bool { return strings.Contains(out.String(), text) }, 5*time.Second, 10*time.Millisecond,
+		"waiting for %q, got %q", text, out.String())
+}
+
+func (s *supervisorTestSuite) TestContextCancelStopsDaemon() {
+	cfg := s.supervisorConfig()
+	cfg.RestartPolicy = cosmovisor.RestartAlways
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var stdout, stderr syncBuffer
+	done := s.runSupervisor(ctx, cosmovisor.NewSupervisor(cfg, &stdout, &stderr), "signals")
+
+	s.waitForOutput(&stdout, "Ready")
+	cancel()
+
+	s.Require().ErrorIs(<-done, context.Canceled)
+	s.Require().Equal("Ready\nGot TERM\n", stdout.String())
+}
+
+func (s *supervisorTestSuite) TestRestartsUntilCrashLoop() {
+	cfg := s.supervisorConfig()
+	cfg.RestartPolicy = cosmovisor.RestartOnFailure
+	cfg.RestartDelay = 10 * time.Millisecond
+	cfg.RestartMaxRestarts = 2
+	cfg.RestartWindow = time.Minute
+
+	var stdout, stderr syncBuffer
+	err := cosmovisor.NewSupervisor(cfg, &stdout, &stderr).Run(context.Background(), []string{"crash"})
+	s.Require().ErrorIs(err, cosmovisor.ErrCrashLoop)
+	s.Require().Equal("Crashing\nCrashing\nCrashing\n", stdout.String())
+}
+
+func (s *supervisorTestSuite) TestPIDFileCoversRestartDelay() {
+	cfg := s.supervisorConfig()
+	cfg.RestartPolicy = cosmovisor.RestartOnFailure
+	cfg.RestartDelay = time.Minute
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var stdout, stderr syncBuffer
+	done := s.runSupervisor(ctx, cosmovisor.NewSupervisor(cfg, &stdout, &stderr), "crash")
+	s.waitForOutput(&stdout, "Crashing")
+
+	// the daemon is gone until the restart, but cosmovisor still owns the home dir
+	s.Require().Eventually(func() bool {
+		_, err := cosmovisor.Rollback(cfg, "genesis", false, false)
+		return errors.Is(err, cosmovisor.ErrDaemonRunning)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	s.Require().ErrorIs(<-done, context.Canceled)
+	s.Require().NoFileExists(cfg.PIDFile())
+}
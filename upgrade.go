@@ -1,6 +1,8 @@
 package cosmovisor
 
 import (
+	"context"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -16,63 +18,70 @@ import (
 
 // DoUpgrade will be called after the log message has been parsed and the process has terminated.
 // We can now make any changes to the underlying directory without interference and leave it
-// in a state, so we can make a proper restart
+// in a state, so we can make a proper restart.
+// Errors map to the exit code for the step that failed.
 func DoUpgrade(cfg *Config, info *UpgradeInfo) error {
 	// If backups are enabled, perform the (expensive) copy.
 	if cfg.DataDir != "" {
 		if err := BackupData(cfg, info); err != nil {
-			return fmt.Errorf("data backup failed: %w", err)
+			return WithExitCode(ExitCodeBackup, fmt.Errorf("data backup failed: %w", err))
 		}
 	}
 	// Simplest case is to switch the link
-	err := EnsureBinary(cfg.UpgradeBin(info.Name))
-	if err == nil {
-		// we have the binary - do it
-		return cfg.SetCurrentUpgrade(info.Name)
-	}
-	// if auto-download is disabled, we fail
-	if !cfg.AllowDownloadBinaries {
-		return fmt.Errorf("binary not present, downloading disabled: %w", err)
-	}
+	if err := EnsureBinary(cfg.UpgradeBin(info.Name)); err != nil {
+		// if auto-download is disabled, we fail
+		if !cfg.AllowDownloadBinaries {
+			return WithExitCode(ExitCodeBinary, fmt.Errorf("binary not present, downloading disabled: %w", err))
+		}
 
-	// if the dir is there already, don't download either
-	if _, err := os.Stat(cfg.UpgradeDir(info.Name)); !os.IsNotExist(err) {
-		return errors.New("upgrade dir already exists, won't overwrite")
+		// If not there, then we try to download it... maybe
+		if err := PrepareUpgrade(cfg, info); err != nil {
+			return err
+		}
 	}
 
-	// If not there, then we try to download it... maybe
-	if err := DownloadBinary(cfg, info); err != nil {
-		return fmt.Errorf("cannot download binary: %w", err)
+	from, _ := cfg.CurrentUpgradeName()
+	if err := cfg.SetCurrentUpgrade(info.Name); err != nil {
+		return WithExitCode(ExitCodeSymlink, err)
 	}
-
-	// and then set the binary again
-	if err := EnsureBinary(cfg.UpgradeBin(info.Name)); err != nil {
-		return fmt.Errorf("downloaded binary doesn't check out: %w", err)
+	// the upgrade is done, a history that can't be written only makes rollbacks harder
+	if err := AppendHistory(cfg, HistoryEntry{Action: HistoryUpgrade, From: from, To: info.Name, Height: info.Height}); err != nil {
+		Logger.Printf("recording upgrade %q in the history: %v", info.Name, err)
 	}
-	return cfg.SetCurrentUpgrade(info.Name)
+	return nil
 }
 
-// DownloadBinary will grab the binary and place it in the proper directory
-func DownloadBinary(cfg *Config, info *UpgradeInfo) error {
-	url, err := GetDownloadURL(info)
-	if err != nil {
-		return err
+// PrepareUpgrade downloads and verifies the binary of an upgrade ahead of time, so that DoUpgrade
+// only has to switch the link once the node halts for it.
+// It does nothing if the binary is there already, and refuses to touch an upgrade dir without a valid binary.
+// Downloading is not subject to AllowDownloadBinaries here, callers decide whether to prepare at all.
+func PrepareUpgrade(cfg *Config, info *UpgradeInfo) error {
+	return prepareUpgrade(context.Background(), cfg, info)
+}
+
+// prepareUpgrade is PrepareUpgrade, giving up on the download once ctx is done
+func prepareUpgrade(ctx context.Context, cfg *Config, info *UpgradeInfo) error {
+	prepareMutex.Lock()
+	defer prepareMutex.Unlock()
+
+	if EnsureBinary(cfg.UpgradeBin(info.Name)) == nil {
+		return nil
 	}
 
-	// download into the bin dir (works for one file)
-	binPath := cfg.UpgradeBin(info.Name)
-	err = getter.GetFile(binPath, url)
+	// if the dir is there already, don't download either
+	if _, err := os.Stat(cfg.UpgradeDir(info.Name)); !os.IsNotExist(err) {
+		return WithExitCode(ExitCodeDownload, errors.New("upgrade dir already exists, won't overwrite"))
+	}
 
-	// if this fails, let's see if it is a zipped directory
-	if err != nil {
-		dirPath := cfg.UpgradeDir(info.Name)
-		err = getter.Get(dirPath, url)
+	if err := downloadBinary(ctx, cfg, info); err != nil {
+		return WithExitCode(ExitCodeDownload, fmt.Errorf("cannot download binary: %w", err))
 	}
-	if err != nil {
-		return err
+
+	// and then check the binary again
+	if err := EnsureBinary(cfg.UpgradeBin(info.Name)); err != nil {
+		return WithExitCode(ExitCodeDownload, fmt.Errorf("downloaded binary doesn't check out: %w", err))
 	}
-	// if it is successful, let's ensure the binary is executable
-	return MarkExecutable(binPath)
+	return nil
 }
 
 // MarkExecutable will try to set the executable bits if not already set
@@ -98,23 +107,30 @@ type UpgradeConfig struct {
 
 // GetDownloadURL will check if there is an arch-dependent binary specified in Info
 func GetDownloadURL(info *UpgradeInfo) (string, error) {
+	_, url, err := getDownloadEntry(context.Background(), info)
+	return url, err
+}
+
+// getDownloadEntry returns the binaries entry to download for this os/arch, and its url.
+// A reference link is fetched until ctx is done.
+func getDownloadEntry(ctx context.Context, info *UpgradeInfo) (string, string, error) {
 	doc := strings.TrimSpace(info.Info)
 	// if this is a url, then we download that and try to get a new doc with the real info
 	if _, err := url.Parse(doc); err == nil {
 		tmpDir, err := ioutil.TempDir("", "upgrade-manager-reference")
 		if err != nil {
-			return "", fmt.Errorf("create tempdir for reference file: %w", err)
+			return "", "", fmt.Errorf("create tempdir for reference file: %w", err)
 		}
 		defer os.RemoveAll(tmpDir)
 
 		refPath := filepath.Join(tmpDir, "ref")
-		if ee := getter.GetFile(refPath, doc); ee != nil {
-			return "", fmt.Errorf("downloading reference link %s: %w", doc, ee)
+		if ee := getter.GetFile(refPath, doc, getter.WithContext(ctx)); ee != nil {
+			return "", "", fmt.Errorf("downloading reference link %s: %w", doc, ee)
 		}
 
 		refBytes, err := ioutil.ReadFile(refPath)
 		if err != nil {
-			return "", fmt.Errorf("reading downloaded reference: %w", err)
+			return "", "", fmt.Errorf("reading downloaded reference: %w", err)
 		}
 		// if download worked properly, then we use this new file as the binary map to parse
 		doc = string(refBytes)
@@ -124,18 +140,53 @@ func GetDownloadURL(info *UpgradeInfo) (string, error) {
 	var config UpgradeConfig
 
 	if err := json.Unmarshal([]byte(doc), &config); err == nil {
-		url, ok := config.Binaries[OSArch()]
+		entry := OSArch()
+		url, ok := config.Binaries[entry]
 		if !ok {
-			url, ok = config.Binaries["any"]
+			entry = "any"
+			url, ok = config.Binaries[entry]
 		}
 		if !ok {
-			return "", fmt.Errorf("cannot find binary for os/arch: neither %s, nor any", OSArch())
+			return "", "", fmt.Errorf("cannot find binary for os/arch: neither %s, nor any", OSArch())
+		}
+
+		return entry, url, nil
+	}
+
+	return "", "", errors.New("upgrade info doesn't contain binary map")
+}
+
+// checkChecksum makes sure the url of a binaries entry has a sha256 or sha512 checksum for getter to verify.
+// Only the entry selected for this os/arch is checked, the others are never downloaded here.
+func checkChecksum(entry, src string) error {
+	var checksum string
+	if i := strings.Index(src, "?"); i >= 0 {
+		if query, err := url.ParseQuery(src[i+1:]); err == nil {
+			checksum = query.Get("checksum")
 		}
+	}
 
-		return url, nil
+	hexLen := map[string]int{"sha256": 64, "sha512": 128}
+	kind, value := "", checksum
+	if i := strings.Index(checksum, ":"); i >= 0 {
+		kind, value = checksum[:i], checksum[i+1:]
+	} else {
+		// getter guesses the type by length
+		for k, n := range hexLen {
+			if len(value) == n {
+				kind = k
+			}
+		}
 	}
 
-	return "", errors.New("upgrade info doesn't contain binary map")
+	n, ok := hexLen[kind]
+	if _, err := hex.DecodeString(value); !ok || err != nil || len(value) != n {
+		if checksum == "" {
+			return fmt.Errorf("binary for %s has no checksum, add ?checksum=sha256:<hash> to %s", entry, src)
+		}
+		return fmt.Errorf("binary for %s needs a sha256 or sha512 checksum, got %q", entry, checksum)
+	}
+	return nil
 }
 
 // OSArch detect the current GOOS/GOARCH combination.
@@ -170,6 +221,22 @@ func (cfg *Config) SetCurrentUpgrade(upgradeName string) error {
 	return nil
 }
 
+// SetCurrentGenesis points the current link back at the genesis dir
+func (cfg *Config) SetCurrentGenesis() error {
+	if err := EnsureBinary(cfg.GenesisBin()); err != nil {
+		return err
+	}
+
+	link := filepath.Join(cfg.Root(), currentLink)
+	if _, err := os.Lstat(link); err == nil {
+		os.Remove(link)
+	}
+	if _, err := cfg.SymLinkToGenesis(); err != nil {
+		return fmt.Errorf("creating current symlink: %w", err)
+	}
+	return nil
+}
+
 // EnsureBinary ensures the file exists and is executable, or returns an error
 func EnsureBinary(path string) error {
 	info, err := os.Stat(path)
@@ -258,6 +258,34 @@ func (s *upgradeTestSuite) TestDownloadBinary() {
 	}
 }
 
+func (s *upgradeTestSuite) TestPrepareUpgrade() {
+	home := copyTestData(s.T(), "download")
+	cfg := &cosmovisor.Config{Home: home, Name: "autod"}
+	bin, err := filepath.Abs("./testdata/repo/raw_binary/autod")
+	s.Require().NoError(err)
+
+	// a broken plan leaves nothing behind
+	bad := &cosmovisor.UpgradeInfo{Name: "amazonas", Info: `{"binaries":{"any": "/no/such/autod"}}`}
+	s.Require().Error(cosmovisor.PrepareUpgrade(cfg, bad))
+	_, err = os.Stat(cfg.UpgradeDir("amazonas"))
+	s.Require().True(os.IsNotExist(err))
+
+	// preparing doesn't need downloads enabled, and doesn't switch to the upgrade
+	info := &cosmovisor.UpgradeInfo{Name: "amazonas", Info: fmt.Sprintf(`{"binaries":{"any": "%s"}}`, bin)}
+	s.Require().NoError(cosmovisor.PrepareUpgrade(cfg, info))
+	s.Require().NoError(cosmovisor.EnsureBinary(cfg.UpgradeBin("amazonas")))
+	current, err := cfg.CurrentUpgradeName()
+	s.Require().NoError(err)
+	s.Require().Equal("genesis", current)
+
+	// preparing again is a no-op, even if the plan can't be fetched anymore
+	s.Require().NoError(cosmovisor.PrepareUpgrade(cfg, bad))
+
+	// and the upgrade takes the binary present path, without downloading
+	s.Require().NoError(cosmovisor.DoUpgrade(cfg, bad))
+	s.assertCurrentLink(*cfg, filepath.Join("upgrades", "amazonas"))
+}
+
 // copyTestData will make a tempdir and then
 // "cp -r" a subdirectory under testdata there
 // returns the directory (which can now be used as Config.Home) and modified safely
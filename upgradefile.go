@@ -0,0 +1,127 @@
+package cosmovisor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// upgradeInfoFileName is the file the SDK writes into the node's data dir when it halts for an upgrade
+	upgradeInfoFileName = "upgrade-info.json"
+	// defaultPollInterval is used when the config doesn't set a poll interval
+	defaultPollInterval = 300 * time.Millisecond
+)
+
+// UpgradeInfoFile is the path of the upgrade-info.json the node writes when it halts for an upgrade,
+// in DataDir if set and in the data dir of Home otherwise
+func (cfg *Config) UpgradeInfoFile() string {
+	if cfg.DataDir != "" {
+		return filepath.Join(cfg.DataDir, upgradeInfoFileName)
+	}
+	return filepath.Join(cfg.Home, "data", upgradeInfoFileName)
+}
+
+// upgradeInfoJSON is the content of upgrade-info.json, as written by the SDK's upgrade keeper
+type upgradeInfoJSON struct {
+	Time   time.Time `json:"time"`
+	Name   string    `json:"name"`
+	Info   string    `json:"info"`
+	Height int64     `json:"height"`
+}
+
+// ReadUpgradeInfoFile reads the upgrade in an upgrade-info.json file.
+// It returns (nil, nil) if the file doesn't exist.
+func ReadUpgradeInfoFile(path string) (*UpgradeInfo, error) {
+	bz, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var content upgradeInfoJSON
+	if err := json.Unmarshal(bz, &content); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if content.Name == "" {
+		return nil, fmt.Errorf("%s has no upgrade name", path)
+	}
+	return &UpgradeInfo{Name: content.Name, Height: content.Height, Time: content.Time, Info: content.Info}, nil
+}
+
+// WatchUpgradeInfoFile polls the upgrade-info.json of cfg every PollInterval until it names an upgrade
+// that isn't the current one, and returns that upgrade.
+// A plan the history says was applied before, as the file still names after a rollback or restore,
+// is only taken once the node writes the file again.
+// Once done is closed, it checks the file a last time, as the node exits right after writing it,
+// and returns (nil, nil) if there is still no new upgrade.
+func WatchUpgradeInfoFile(cfg *Config, done <-chan struct{}) (*UpgradeInfo, error) {
+	// the plan the current binary is for has been applied, so it never triggers again
+	current, err := cfg.CurrentUpgradeName()
+	if err != nil {
+		return nil, err
+	}
+	history, err := ReadHistory(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("reading history: %w", err)
+	}
+
+	path := cfg.UpgradeInfoFile()
+	var lastMod time.Time
+	var lastSize int64 = -1
+	if stat, err := os.Stat(path); err == nil {
+		if info, _ := ReadUpgradeInfoFile(path); info != nil && appliedUpgrade(history, info) {
+			lastMod, lastSize = stat.ModTime(), stat.Size()
+		}
+	}
+	check := func() *UpgradeInfo {
+		stat, err := os.Stat(path)
+		if err != nil || (stat.ModTime().Equal(lastMod) && stat.Size() == lastSize) {
+			return nil
+		}
+		info, err := ReadUpgradeInfoFile(path)
+		if err != nil {
+			// most likely caught while the node was writing it, so look again next time
+			return nil
+		}
+		lastMod, lastSize = stat.ModTime(), stat.Size()
+		if info == nil || info.Name == current {
+			return nil
+		}
+		return info
+	}
+
+	interval := cfg.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if info := check(); info != nil {
+			Logger.Printf("found upgrade %q at %s in %s", info.Name, info.DueAt(), path)
+			return info, nil
+		}
+		select {
+		case <-done:
+			if info := check(); info != nil {
+				Logger.Printf("found upgrade %q at %s in %s", info.Name, info.DueAt(), path)
+				return info, nil
+			}
+			return nil, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// appliedUpgrade reports whether the history has info been upgraded to already.
+// Upgrades found in the logs or planned by time are recorded without a height, those match on the name alone.
+func appliedUpgrade(history []HistoryEntry, info *UpgradeInfo) bool {
+	return lastUpgrade(history, func(e HistoryEntry) bool {
+		return e.To == info.Name && (e.Height == 0 || info.Height == 0 || e.Height == info.Height)
+	}) != nil
+}
@@ -0,0 +1,130 @@
+package cosmovisor_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/provenance-io/cosmovisor"
+)
+
+func TestReadUpgradeInfoFile(t *testing.T) {
+	dir := t.TempDir()
+	cases := map[string]struct {
+		content   string
+		expect    *cosmovisor.UpgradeInfo
+		expectErr bool
+	}{
+		"height plan": {
+			content: `{"name":"v2","time":"0001-01-01T00:00:00Z","height":100,"info":"{\"binaries\":{}}"}`,
+			expect:  &cosmovisor.UpgradeInfo{Name: "v2", Height: 100, Info: `{"binaries":{}}`},
+		},
+		"time plan": {
+			content: `{"name":"v3","time":"2022-01-01T00:00:00Z"}`,
+			expect:  &cosmovisor.UpgradeInfo{Name: "v3", Time: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		"partly written": {
+			content:   `{"name":"v2","he`,
+			expectErr: true,
+		},
+		"no name": {
+			content:   `{"height":100}`,
+			expectErr: true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, "upgrade-info.json")
+			require.NoError(t, os.WriteFile(path, []byte(tc.content), 0600))
+			info, err := cosmovisor.ReadUpgradeInfoFile(path)
+			if tc.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, info)
+		})
+	}
+
+	info, err := cosmovisor.ReadUpgradeInfoFile(filepath.Join(dir, "missing.json"))
+	require.NoError(t, err)
+	require.Nil(t, info)
+}
+
+func TestWatchUpgradeInfoFile(t *testing.T) {
+	home := copyTestData(t, "validate")
+	cfg := &cosmovisor.Config{Home: home, Name: "dummyd", PollInterval: 10 * time.Millisecond}
+	require.NoError(t, os.MkdirAll(filepath.Dir(cfg.UpgradeInfoFile()), 0755))
+
+	// nothing there until done
+	done := make(chan struct{})
+	close(done)
+	info, err := cosmovisor.WatchUpgradeInfoFile(cfg, done)
+	require.NoError(t, err)
+	require.Nil(t, info)
+
+	// a plan written while watching is found
+	found := make(chan *cosmovisor.UpgradeInfo)
+	go func() {
+		info, err := cosmovisor.WatchUpgradeInfoFile(cfg, make(chan struct{}))
+		require.NoError(t, err)
+		found <- info
+	}()
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, os.WriteFile(cfg.UpgradeInfoFile(), []byte(`{"name":"chain2","height":49}`), 0600))
+	select {
+	case info = <-found:
+		require.Equal(t, &cosmovisor.UpgradeInfo{Name: "chain2", Height: 49}, info)
+	case <-time.After(5 * time.Second):
+		t.Fatal("upgrade-info.json was not picked up")
+	}
+
+	// but not once that plan is the current one
+	require.NoError(t, cfg.SetCurrentUpgrade("chain2"))
+	info, err = cosmovisor.WatchUpgradeInfoFile(cfg, done)
+	require.NoError(t, err)
+	require.Nil(t, info)
+
+	// nor after rolling back past it, until the node halts for it again
+	require.NoError(t, cosmovisor.AppendHistory(cfg, cosmovisor.HistoryEntry{Action: cosmovisor.HistoryUpgrade, From: "genesis", To: "chain2", Height: 49}))
+	require.NoError(t, cfg.SetCurrentGenesis())
+	info, err = cosmovisor.WatchUpgradeInfoFile(cfg, done)
+	require.NoError(t, err)
+	require.Nil(t, info)
+	stale := time.Now().Add(-time.Minute)
+	require.NoError(t, os.Chtimes(cfg.UpgradeInfoFile(), stale, stale))
+	go func() {
+		info, err := cosmovisor.WatchUpgradeInfoFile(cfg, make(chan struct{}))
+		require.NoError(t, err)
+		found <- info
+	}()
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, os.WriteFile(cfg.UpgradeInfoFile(), []byte(`{"name":"chain2","height":49}`), 0600))
+	select {
+	case info = <-found:
+		require.Equal(t, &cosmovisor.UpgradeInfo{Name: "chain2", Height: 49}, info)
+	case <-time.After(5 * time.Second):
+		t.Fatal("upgrade-info.json written again was not picked up")
+	}
+}
+
+func TestWatchUpgradeInfoFileAppliedWithoutHeight(t *testing.T) {
+	home := copyTestData(t, "validate")
+	data := filepath.Join(t.TempDir(), "node-data")
+	cfg := &cosmovisor.Config{Home: home, Name: "dummyd", DataDir: data, PollInterval: 10 * time.Millisecond}
+	require.Equal(t, filepath.Join(data, "upgrade-info.json"), cfg.UpgradeInfoFile())
+	require.NoError(t, os.MkdirAll(data, 0755))
+	require.NoError(t, os.WriteFile(cfg.UpgradeInfoFile(), []byte(`{"name":"chain2","height":49}`), 0600))
+
+	// chain2 was found in the logs, so the history has no height for it
+	require.NoError(t, cosmovisor.AppendHistory(cfg, cosmovisor.HistoryEntry{Action: cosmovisor.HistoryUpgrade, From: "genesis", To: "chain2"}))
+	done := make(chan struct{})
+	close(done)
+	info, err := cosmovisor.WatchUpgradeInfoFile(cfg, done)
+	require.NoError(t, err)
+	require.Nil(t, info)
+}
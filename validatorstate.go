@@ -0,0 +1,92 @@
+package cosmovisor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// validatorStateFile is where the node records the last height, round and step its validator signed
+const validatorStateFile = "priv_validator_state.json"
+
+// ErrValidatorStateBackwards is returned when a restore would make the validator forget what it signed
+var ErrValidatorStateBackwards = errors.New("restoring would move the validator state backwards, which risks double signing")
+
+// ValidatorState is the last height, round and step a validator signed at
+type ValidatorState struct {
+	Height int64
+	Round  int64
+	Step   int64
+}
+
+func (v ValidatorState) String() string {
+	return fmt.Sprintf("height %d round %d step %d", v.Height, v.Round, v.Step)
+}
+
+// Before reports whether v was signed before o
+func (v ValidatorState) Before(o ValidatorState) bool {
+	if v.Height != o.Height {
+		return v.Height < o.Height
+	}
+	if v.Round != o.Round {
+		return v.Round < o.Round
+	}
+	return v.Step < o.Step
+}
+
+// readValidatorState reads the priv_validator_state.json at path, it returns nil, nil if there is none.
+// The node writes the height as a string, and the round and step as numbers, either is accepted.
+func readValidatorState(path string) (*ValidatorState, error) {
+	bz, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(bz, &fields); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	var v ValidatorState
+	for key, n := range map[string]*int64{"height": &v.Height, "round": &v.Round, "step": &v.Step} {
+		raw, ok := fields[key]
+		if !ok {
+			continue
+		}
+		if *n, err = strconv.ParseInt(strings.Trim(string(raw), `"`), 10, 64); err != nil {
+			return nil, fmt.Errorf("reading %s in %s: %w", key, path, err)
+		}
+	}
+	return &v, nil
+}
+
+// checkValidatorState makes sure the validator state restored at restored isn't behind the one at replaced.
+// Both are either a priv_validator_state.json, or a dir that may hold one.
+func checkValidatorState(restored, replaced string) error {
+	if info, err := os.Stat(restored); err == nil && info.IsDir() {
+		restored, replaced = filepath.Join(restored, validatorStateFile), filepath.Join(replaced, validatorStateFile)
+	} else if filepath.Base(restored) != validatorStateFile {
+		return nil
+	}
+
+	was, err := readValidatorState(replaced)
+	if err != nil || was == nil {
+		return err
+	}
+	now, err := readValidatorState(restored)
+	if err != nil {
+		return err
+	}
+	if now == nil {
+		return fmt.Errorf("%w: %s is at %s, the backup has none", ErrValidatorStateBackwards, replaced, was)
+	}
+	if now.Before(*was) {
+		return fmt.Errorf("%w: %s is at %s, the backup at %s", ErrValidatorStateBackwards, replaced, was, now)
+	}
+	return nil
+}
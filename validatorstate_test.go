@@ -0,0 +1,37 @@
+package cosmovisor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadValidatorState(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, validatorStateFile)
+
+	v, err := readValidatorState(file)
+	require.NoError(t, err)
+	require.Nil(t, v)
+
+	// the node writes the height as a string, a number is read too
+	for _, content := range []string{
+		`{"height": "1234", "round": 2, "step": 3, "signature": "c2ln"}`,
+		`{"height": 1234, "round": "2", "step": 3}`,
+	} {
+		require.NoError(t, os.WriteFile(file, []byte(content), 0600))
+		v, err = readValidatorState(file)
+		require.NoError(t, err)
+		require.Equal(t, &ValidatorState{Height: 1234, Round: 2, Step: 3}, v)
+	}
+
+	require.NoError(t, os.WriteFile(file, []byte(`{"height": "twelve"}`), 0600))
+	_, err = readValidatorState(file)
+	require.Error(t, err)
+
+	require.True(t, ValidatorState{Height: 9, Round: 5, Step: 3}.Before(ValidatorState{Height: 10}))
+	require.True(t, ValidatorState{Height: 10, Round: 1, Step: 3}.Before(ValidatorState{Height: 10, Round: 2, Step: 1}))
+	require.False(t, ValidatorState{Height: 10, Step: 3}.Before(ValidatorState{Height: 10, Step: 3}))
+}